@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultActuatorConcurrency = 4
+	maxActuatorConcurrency     = 32
+)
+
+// actuatorConfig is the optional configuration of the "actuator" type, e.g.
+//
+//	concurrency: 4
+//	metrics: [jvm.memory.used, http.server.requests, "hikaricp.*"]
+type actuatorConfig struct {
+	// Concurrency is the maximum number of the metrics being fetched at the same time.
+	Concurrency int `yaml:"concurrency"`
+	// Metrics is the allowlist of the metric names (patterns), all discovered metrics will be fetched if it is empty.
+	Metrics []string `yaml:"metrics"`
+}
+
+type actuatorNames struct {
+	Names []string `json:"names"`
+}
+
+type actuatorMetric struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	BaseUnit     string `json:"baseUnit"`
+	Measurements []struct {
+		Statistic string   `json:"statistic"`
+		Value     *float64 `json:"value"`
+	} `json:"measurements"`
+}
+
+func validateActuatorConfig(config []byte) error {
+	_, err := parseActuatorConfig(config)
+	return err
+}
+
+func parseActuatorConfig(config []byte) (*actuatorConfig, error) {
+	cfg := &actuatorConfig{}
+	if err := yaml.UnmarshalStrict(config, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultActuatorConcurrency
+	}
+	if cfg.Concurrency < 0 || cfg.Concurrency > maxActuatorConcurrency {
+		return nil, fmt.Errorf("concurrency must be between 1 and %d", maxActuatorConcurrency)
+	}
+	for i, pattern := range cfg.Metrics {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("metrics[%d]: invalid pattern \"%s\"", i, pattern)
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *actuatorConfig) isAllowed(name string) bool {
+	if len(cfg.Metrics) == 0 {
+		return true
+	}
+	for _, pattern := range cfg.Metrics {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func collectActuator(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error) {
+	config, err := loadTypeConfig(e)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseActuatorConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid actuator config: %s", err.Error())
+	}
+	body, err := getBody(c, e, t, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	discovered := &actuatorNames{}
+	if err = json.Unmarshal(body, discovered); err != nil {
+		return nil, fmt.Errorf("invalid actuator metric names: %s", err.Error())
+	}
+	names := make([]string, 0, len(discovered.Names))
+	for _, name := range discovered.Names {
+		if cfg.isAllowed(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no metric is discovered")
+	}
+	metrics := make([]*actuatorMetric, len(names))
+	errs := make([]error, len(names))
+	//bounds the requests sent to the POD at the same time.
+	tokens := make(chan bool, cfg.Concurrency)
+	wg := sync.WaitGroup{}
+	for i, name := range names {
+		wg.Add(1)
+		tokens <- true
+		go func(i int, name string) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			metrics[i], errs[i] = fetchActuatorMetric(c, e, t, name)
+		}(i, name)
+	}
+	wg.Wait()
+	builder := newFamilyBuilder()
+	failed := 0
+	var lastErr error
+	for i, metric := range metrics {
+		if errs[i] != nil {
+			failed++
+			lastErr = errs[i]
+			log.Debugf("Failed to fetch actuator metric %s of POD: %s, error: %s", names[i], e.Pod.Name, errs[i].Error())
+			continue
+		}
+		metric.convert(builder)
+	}
+	if failed == len(names) {
+		return nil, fmt.Errorf("failed to fetch all %d metrics, last error: %s", failed, lastErr.Error())
+	}
+	return builder.build(e.Limits)
+}
+
+func fetchActuatorMetric(c *scrapeClient, e *PODEvent, t scrapeTarget, name string) (*actuatorMetric, error) {
+	t.Path = strings.TrimSuffix(t.Path, "/") + "/" + url.PathEscape(name)
+	body, err := getBody(c, e, t, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	metric := &actuatorMetric{}
+	if err = json.Unmarshal(body, metric); err != nil {
+		return nil, fmt.Errorf("invalid actuator metric: %s", err.Error())
+	}
+	if metric.Name == "" {
+		metric.Name = name
+	}
+	return metric, nil
+}
+
+// convert maps the statistics with the naming conventions of Micrometer's Prometheus registry, e.g.
+// "http.server.requests" with the base unit "seconds": COUNT -> "http_server_requests_seconds_count",
+// TOTAL_TIME -> "http_server_requests_seconds_sum", MAX -> "http_server_requests_seconds_max".
+// The measurements are aggregated over all tags by the actuator.
+func (metric *actuatorMetric) convert(builder *familyBuilder) {
+	name := sanitizeMetricName(metric.Name)
+	if unit := sanitizeMetricName(metric.BaseUnit); unit != "" && !strings.HasSuffix(name, "_"+unit) {
+		name += "_" + unit
+	}
+	//COUNT is the number of the events for timers & distribution summaries, otherwise it is a counter.
+	distribution := false
+	for _, measurement := range metric.Measurements {
+		if measurement.Statistic == "TOTAL_TIME" || measurement.Statistic == "TOTAL" {
+			distribution = true
+		}
+	}
+	for _, measurement := range metric.Measurements {
+		if measurement.Value == nil {
+			continue
+		}
+		suffix, metricType := "", dto.MetricType_UNTYPED
+		switch measurement.Statistic {
+		case "VALUE":
+			metricType = dto.MetricType_GAUGE
+		case "COUNT":
+			suffix, metricType = "_total", dto.MetricType_COUNTER
+			if distribution {
+				suffix = "_count"
+			}
+		case "TOTAL", "TOTAL_TIME":
+			suffix, metricType = "_sum", dto.MetricType_COUNTER
+		case "MAX":
+			suffix, metricType = "_max", dto.MetricType_GAUGE
+		case "ACTIVE_TASKS":
+			suffix, metricType = "_active_count", dto.MetricType_GAUGE
+		case "DURATION":
+			suffix, metricType = "_duration_sum", dto.MetricType_GAUGE
+		default:
+			if measurement.Statistic != "UNKNOWN" {
+				suffix = "_" + strings.ToLower(sanitizeMetricName(measurement.Statistic))
+			}
+		}
+		base := name
+		if suffix == "_total" {
+			base = strings.TrimSuffix(base, "_total")
+		}
+		builder.add(base+suffix, metric.Description, metricType, *measurement.Value, nil)
+	}
+}
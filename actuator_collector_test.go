@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestActuatorConvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric string
+		want   string
+	}{
+		{
+			name:   "gauge with unit",
+			metric: `{"name": "jvm.memory.used", "description": "Used memory.", "baseUnit": "bytes", "measurements": [{"statistic": "VALUE", "value": 1024}]}`,
+			want: `# HELP jvm_memory_used_bytes Used memory.
+# TYPE jvm_memory_used_bytes gauge
+jvm_memory_used_bytes 1024
+`,
+		},
+		{
+			name:   "timer",
+			metric: `{"name": "http.server.requests", "baseUnit": "seconds", "measurements": [{"statistic": "COUNT", "value": 10}, {"statistic": "TOTAL_TIME", "value": 2.5}, {"statistic": "MAX", "value": 0.5}]}`,
+			want: `# TYPE http_server_requests_seconds_count counter
+http_server_requests_seconds_count 10
+# TYPE http_server_requests_seconds_max gauge
+http_server_requests_seconds_max 0.5
+# TYPE http_server_requests_seconds_sum counter
+http_server_requests_seconds_sum 2.5
+`,
+		},
+		{
+			name:   "counter",
+			metric: `{"name": "logback.events.total", "measurements": [{"statistic": "COUNT", "value": 3}]}`,
+			want: `# TYPE logback_events_total counter
+logback_events_total 3
+`,
+		},
+		{
+			name:   "long task timer & unknown statistics",
+			metric: `{"name": "tasks", "measurements": [{"statistic": "ACTIVE_TASKS", "value": 2}, {"statistic": "DURATION", "value": 7}, {"statistic": "UNKNOWN", "value": 1}, {"statistic": "Percentile", "value": 4}, {"statistic": "VALUE"}]}`,
+			want: `# TYPE tasks untyped
+tasks 1
+# TYPE tasks_active_count gauge
+tasks_active_count 2
+# TYPE tasks_duration_sum gauge
+tasks_duration_sum 7
+# TYPE tasks_percentile untyped
+tasks_percentile 4
+`,
+		},
+	}
+	for _, test := range tests {
+		metric := &actuatorMetric{}
+		if err := json.Unmarshal([]byte(test.metric), metric); err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		builder := newFamilyBuilder()
+		metric.convert(builder)
+		families, err := builder.build(scrapeLimits{})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		if got := encodeForTest(t, families); got != test.want {
+			t.Errorf("%s: unexpected metrics:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseActuatorConfig(t *testing.T) {
+	for _, config := range []string{"concurrency: -1\n", "concurrency: 33\n", "metrics: [\"[\"]\n", "unknown: 1\n"} {
+		if _, err := parseActuatorConfig([]byte(config)); err == nil {
+			t.Errorf("invalid config accepted: %q", config)
+		}
+	}
+}
+
+func TestCollectActuator(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/actuator/metrics":
+			w.Write([]byte(`{"names": ["jvm.threads.live", "hikaricp.connections", "process.uptime", "broken"]}`))
+		case "/actuator/metrics/jvm.threads.live":
+			w.Write([]byte(`{"name": "jvm.threads.live", "baseUnit": "threads", "measurements": [{"statistic": "VALUE", "value": 12}]}`))
+		case "/actuator/metrics/hikaricp.connections":
+			w.Write([]byte(`{"measurements": [{"statistic": "VALUE", "value": 5}]}`))
+		case "/actuator/metrics/process.uptime":
+			t.Errorf("metric out of the allowlist is fetched")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	e, target := newTestTarget(t, handler, "actuator", "metrics: [jvm.*, \"hikaricp.*\", broken]\n")
+	want := `# TYPE hikaricp_connections gauge
+hikaricp_connections 5
+# TYPE jvm_threads_live_threads gauge
+jvm_threads_live_threads 12
+`
+	if got := collectForTest(t, e, target); got != want {
+		t.Errorf("unexpected metrics:\n%s\nwant:\n%s", got, want)
+	}
+	e, target = newTestTarget(t, handler, "actuator", "metrics: [broken]\n")
+	if _, err := fetchMetrics(&scrapeClient{timeout: e.Timeout}, e, target); err == nil || !strings.Contains(err.Error(), "failed to fetch all 1 metrics") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// registerAdminAPI exposes the bridge's internal states on the same port as its own metrics.
+func registerAdminAPI() {
+	http.HandleFunc("/api/v1/targets", handleTargets)
+	http.HandleFunc("/api/v1/dryrun/actions", handleDryRunActions)
+	http.HandleFunc("/api/v1/dryrun/pushes", handleDryRunPushes)
+}
+
+// handleTargets lists the status of every annotated POD, "?valid=false" returns the invalid ones ONLY.
+func handleTargets(w http.ResponseWriter, r *http.Request) {
+	statuses := listTargetStatuses()
+	if valid := r.URL.Query().Get("valid"); valid != "" {
+		filtered := statuses[:0]
+		for _, s := range statuses {
+			if (valid == "true") == s.Valid {
+				filtered = append(filtered, s)
+			}
+		}
+		statuses = filtered
+	}
+	writeJSON(w, statuses)
+}
+
+// handleDryRunActions lists the recent actions recorded in dry-run mode, "?action=push" returns the pushes ONLY.
+func handleDryRunActions(w http.ResponseWriter, r *http.Request) {
+	if dryRun == nil {
+		http.Error(w, "NOT running in dry-run mode.", http.StatusNotFound)
+		return
+	}
+	actions := dryRun.listActions()
+	if action := r.URL.Query().Get("action"); action != "" {
+		filtered := actions[:0]
+		for _, a := range actions {
+			if a.Action == action {
+				filtered = append(filtered, a)
+			}
+		}
+		actions = filtered
+	}
+	writeJSON(w, actions)
+}
+
+// handleDryRunPushes lists the latest payload which would have been pushed to every grouping key.
+func handleDryRunPushes(w http.ResponseWriter, r *http.Request) {
+	if dryRun == nil {
+		http.Error(w, "NOT running in dry-run mode.", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, dryRun.listPushes())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to write admin API response, error: %s", err.Error())
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func validatePort(port string) error {
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("invalid port \"%s\"", port)
+	}
+	return nil
+}
+
+func validatePath(path string) error {
+	if path == "" {
+		return nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("path \"%s\" must start with \"/\"", path)
+	}
+	if strings.ContainsAny(path, " \t\r\n") {
+		return fmt.Errorf("path \"%s\" CANNOT contain any whitespace", path)
+	}
+	return nil
+}
+
+func parsePositiveDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("a positive duration is required")
+	}
+	return d, nil
+}
+
+func parseNonNegativeDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("a non-negative duration is required")
+	}
+	return d, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	"strconv"
+	"strings"
+)
+
+var (
+	//states of the worker slots in the scoreboard of mod_status.
+	apacheScoreboardStates = []struct {
+		Key   byte
+		State string
+	}{
+		{'_', "idle"},
+		{'S', "startup"},
+		{'R', "read"},
+		{'W', "reply"},
+		{'K', "keepalive"},
+		{'D', "dns"},
+		{'C', "closing"},
+		{'L', "logging"},
+		{'G', "graceful_stop"},
+		{'I', "idle_cleanup"},
+		{'.', "open_slot"},
+	}
+)
+
+func collectApache(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error) {
+	//mod_status returns a HTML page without the "auto" parameter.
+	if !strings.Contains(t.Path, "?") {
+		t.Path += "?auto"
+	}
+	body, err := getBody(c, e, t, "text/plain")
+	if err != nil {
+		return nil, err
+	}
+	return parseApacheStatus(string(body), e.Limits)
+}
+
+// parseApacheStatus converts the machine readable page of mod_status ("server-status?auto"), which consists of "Key: value" lines.
+func parseApacheStatus(page string, limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	builder := newFamilyBuilder()
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(page))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if key == "Scoreboard" {
+			found = true
+			addApacheScoreboard(builder, value)
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "Total Accesses":
+			builder.add("apache_accesses_total", "Current total apache accesses.", dto.MetricType_COUNTER, f, nil)
+		case "Total kBytes":
+			builder.add("apache_sent_kilobytes_total", "Current total kbytes sent.", dto.MetricType_COUNTER, f, nil)
+		case "Uptime":
+			builder.add("apache_uptime_seconds_total", "Current uptime in seconds.", dto.MetricType_COUNTER, f, nil)
+		case "CPULoad":
+			builder.add("apache_cpuload", "The current percentage CPU used by each worker and in total by all workers combined.", dto.MetricType_GAUGE, f, nil)
+		case "BusyWorkers":
+			builder.add("apache_workers", "Apache worker statuses.", dto.MetricType_GAUGE, f, map[string]string{"state": "busy"})
+		case "IdleWorkers":
+			builder.add("apache_workers", "Apache worker statuses.", dto.MetricType_GAUGE, f, map[string]string{"state": "idle"})
+		case "ConnsTotal":
+			builder.add("apache_connections", "Apache connection statuses.", dto.MetricType_GAUGE, f, map[string]string{"state": "total"})
+		case "ConnsAsyncWriting":
+			builder.add("apache_connections", "Apache connection statuses.", dto.MetricType_GAUGE, f, map[string]string{"state": "writing"})
+		case "ConnsAsyncKeepAlive":
+			builder.add("apache_connections", "Apache connection statuses.", dto.MetricType_GAUGE, f, map[string]string{"state": "keepalive"})
+		case "ConnsAsyncClosing":
+			builder.add("apache_connections", "Apache connection statuses.", dto.MetricType_GAUGE, f, map[string]string{"state": "closing"})
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("invalid server-status page, no known field is found")
+	}
+	return builder.build(limits)
+}
+
+func addApacheScoreboard(builder *familyBuilder, scoreboard string) {
+	counts := map[byte]int{}
+	for i := 0; i < len(scoreboard); i++ {
+		counts[scoreboard[i]]++
+	}
+	for _, s := range apacheScoreboardStates {
+		builder.add("apache_scoreboard", "Apache scoreboard statuses.", dto.MetricType_GAUGE, float64(counts[s.Key]), map[string]string{"state": s.State})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseApacheStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string
+		err  bool
+	}{
+		{
+			name: "event mpm",
+			page: `localhost
+ServerVersion: Apache/2.4.57 (Unix)
+Total Accesses: 1200
+Total kBytes: 345
+CPULoad: .0123
+Uptime: 3600
+BusyWorkers: 2
+IdleWorkers: 48
+ConnsTotal: 3
+ConnsAsyncWriting: 0
+ConnsAsyncKeepAlive: 1
+ConnsAsyncClosing: 2
+Scoreboard: __W_K.._R
+`,
+			want: `# HELP apache_accesses_total Current total apache accesses.
+# TYPE apache_accesses_total counter
+apache_accesses_total 1200
+# HELP apache_connections Apache connection statuses.
+# TYPE apache_connections gauge
+apache_connections{state="closing"} 2
+apache_connections{state="keepalive"} 1
+apache_connections{state="total"} 3
+apache_connections{state="writing"} 0
+# HELP apache_cpuload The current percentage CPU used by each worker and in total by all workers combined.
+# TYPE apache_cpuload gauge
+apache_cpuload 0.0123
+# HELP apache_scoreboard Apache scoreboard statuses.
+# TYPE apache_scoreboard gauge
+apache_scoreboard{state="closing"} 0
+apache_scoreboard{state="dns"} 0
+apache_scoreboard{state="graceful_stop"} 0
+apache_scoreboard{state="idle_cleanup"} 0
+apache_scoreboard{state="idle"} 4
+apache_scoreboard{state="keepalive"} 1
+apache_scoreboard{state="logging"} 0
+apache_scoreboard{state="open_slot"} 2
+apache_scoreboard{state="read"} 1
+apache_scoreboard{state="reply"} 1
+apache_scoreboard{state="startup"} 0
+# HELP apache_sent_kilobytes_total Current total kbytes sent.
+# TYPE apache_sent_kilobytes_total counter
+apache_sent_kilobytes_total 345
+# HELP apache_uptime_seconds_total Current uptime in seconds.
+# TYPE apache_uptime_seconds_total counter
+apache_uptime_seconds_total 3600
+# HELP apache_workers Apache worker statuses.
+# TYPE apache_workers gauge
+apache_workers{state="busy"} 2
+apache_workers{state="idle"} 48
+`,
+		},
+		{
+			name: "unknown & invalid fields",
+			page: "ServerVersion: Apache/2.4.57\nTotal Accesses: many\nUptime: 10\n",
+			want: `# HELP apache_uptime_seconds_total Current uptime in seconds.
+# TYPE apache_uptime_seconds_total counter
+apache_uptime_seconds_total 10
+`,
+		},
+		{name: "html page", page: "<html><body><h1>Apache Server Status</h1></body></html>", err: true},
+	}
+	for _, test := range tests {
+		families, err := parseApacheStatus(test.page, scrapeLimits{})
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		if got := encodeForTest(t, families); got != test.want {
+			t.Errorf("%s: unexpected metrics:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCollectApache(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/server-status" || r.URL.RawQuery != "auto" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("Total Accesses: 7\n"))
+	})
+	e, target := newTestTarget(t, handler, "apache", "")
+	if got := collectForTest(t, e, target); !strings.Contains(got, "apache_accesses_total 7\n") {
+		t.Errorf("unexpected metrics:\n%s", got)
+	}
+	target.Path = "/server-status"
+	if got := collectForTest(t, e, target); !strings.Contains(got, "apache_accesses_total 7\n") {
+		t.Errorf("unexpected metrics of the path without \"?auto\":\n%s", got)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"net/http/httputil"
+	"os"
+	"strings"
+)
+
+const checkUsage = `Usage: crystal-bridge check <annotations|scrape|push> [arguments]
+
+  annotations -f pod.yaml          validate the metrics annotations of the PODs (or workload templates) in the file.
+  scrape --pod namespace/name      fetch the POD's metrics once and print the parsed metric families.
+  push --pod namespace/name        fetch the POD's metrics once and print the push request, "--push" really pushes them.
+`
+
+// runCheckCommand runs "crystal-bridge check ..." and returns the exit code.
+func runCheckCommand(arguments []string) int {
+	if len(arguments) == 0 {
+		fmt.Fprint(os.Stderr, checkUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("check "+arguments[0], flag.ExitOnError)
+	arg := defineArgs(fs)
+	file := fs.String("f", "", "YAML or JSON file which contains the PODs to be validated.")
+	podName := fs.String("pod", "", "POD to be checked, formatted as \"namespace/name\".")
+	push := fs.Bool("push", false, "really push the metrics to the push gateway in \"check push\", otherwise the request is printed ONLY.")
+	fs.Parse(arguments[1:])
+	args = arg
+	if err := finalizeArgs(args); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	log.SetLevel(log.Level(args.LogLevel))
+	var err error
+	switch arguments[0] {
+	case "annotations":
+		err = checkAnnotations(*file)
+	case "scrape":
+		err = checkScrape(*podName)
+	case "push":
+		err = checkPush(*podName, !*push || args.DryRun)
+	default:
+		fmt.Fprint(os.Stderr, checkUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+func checkAnnotations(file string) error {
+	if file == "" {
+		return fmt.Errorf("argument \"-f\" is required")
+	}
+	pods, err := loadPodsFromFile(file)
+	if err != nil {
+		return err
+	}
+	invalid := 0
+	for _, pod := range pods {
+		e := &PODEvent{Pod: pod}
+		e.ParseAnnotation()
+		printPodEvent(e)
+		if e.HasAnnotation && !e.monitorable() {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d PODs have invalid annotations", invalid, len(pods))
+	}
+	return nil
+}
+
+// loadPodsFromFile reads every document of the file, workloads are represented by their POD templates.
+func loadPodsFromFile(file string) ([]*corev1.Pod, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	decoder := yaml.NewYAMLOrJSONDecoder(f, 4096)
+	pods := []*corev1.Pod{}
+	for {
+		raw := json.RawMessage{}
+		if err = decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+		pod, err := podFromDocument(raw)
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, pod)
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no POD found in file: %s", file)
+	}
+	return pods, nil
+}
+
+func podFromDocument(raw []byte) (*corev1.Pod, error) {
+	var workload struct {
+		Kind     string            `json:"kind"`
+		Metadata metav1.ObjectMeta `json:"metadata"`
+		Spec     struct {
+			Template *corev1.PodTemplateSpec `json:"template"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(raw, &workload); err != nil {
+		return nil, err
+	}
+	if workload.Kind == "Pod" {
+		pod := &corev1.Pod{}
+		return pod, json.Unmarshal(raw, pod)
+	}
+	if workload.Spec.Template == nil {
+		return nil, fmt.Errorf("%s \"%s\" has no POD template", workload.Kind, workload.Metadata.Name)
+	}
+	pod := &corev1.Pod{ObjectMeta: workload.Spec.Template.ObjectMeta, Spec: workload.Spec.Template.Spec}
+	if pod.Name == "" {
+		pod.Name = strings.ToLower(workload.Kind) + "/" + workload.Metadata.Name
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = workload.Metadata.Namespace
+	}
+	return pod, nil
+}
+
+func printPodEvent(e *PODEvent) {
+	fmt.Printf("POD: %s/%s\n", e.Pod.Namespace, e.Pod.Name)
+	if !e.HasAnnotation {
+		fmt.Printf("  NOT annotated with \"%s/type\", skipped.\n\n", args.AnnotationPrefixTag)
+		return
+	}
+	fmt.Printf("  type:      %s\n", e.MetricType)
+	fmt.Printf("  endpoints: %s\n", e.Endpoints)
+	for _, t := range e.Targets {
+		fmt.Printf("  target:    %s\n", t.String())
+	}
+	if e.LabeledNamespace != "" {
+		fmt.Printf("  namespace: %s\n", e.LabeledNamespace)
+	}
+	fmt.Printf("  scheme:    %s\n", e.Scheme)
+	fmt.Printf("  interval:  %s\n", e.FechingInterval)
+	fmt.Printf("  timeout:   %s\n", e.FechingTimeout)
+	if e.AuthSecret != "" {
+		fmt.Printf("  secret:    %s\n", e.AuthSecret)
+	}
+	if e.Tenant != "" {
+		fmt.Printf("  tenant:    %s\n", e.Tenant)
+	}
+	if e.monitorable() {
+		fmt.Printf("  result:    VALID\n\n")
+		return
+	}
+	fmt.Printf("  result:    INVALID\n")
+	for _, err := range e.Errors {
+		fmt.Printf("    - %s\n", err)
+	}
+	fmt.Println()
+}
+
+// loadPodEvent gets the POD from the remote Kubernetes cluster and parses its annotations.
+func loadPodEvent(podName string) (*PODEvent, error) {
+	parts := strings.SplitN(podName, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("argument \"--pod\" must be formatted as \"namespace/name\"")
+	}
+	if err := initializeK8SClient(); err != nil {
+		return nil, err
+	}
+	pod, err := k8sClient.CoreV1().Pods(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	e := &PODEvent{Pod: pod, Status: POD_UPDATE}
+	e.ParseAnnotation()
+	printPodEvent(e)
+	if !e.monitorable() {
+		return nil, fmt.Errorf("POD %s CANNOT be scraped", podName)
+	}
+	if pod.Status.PodIP == "" {
+		return nil, fmt.Errorf("POD %s has no IP", podName)
+	}
+	return e, nil
+}
+
+func checkScrape(podName string) error {
+	e, err := loadPodEvent(podName)
+	if err != nil {
+		return err
+	}
+	c := &scrapeClient{timeout: e.Timeout}
+	var all []*dto.MetricFamily
+	for _, t := range e.Targets {
+		families, err := fetchMetrics(c, e, t)
+		if err != nil {
+			return fmt.Errorf("%s: %s", t.String(), err.Error())
+		}
+		data, err := encodeMetricFamilies(families)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Fetched %d metric families from %s:\n\n%s\n", len(families), t.String(), data)
+		all = append(all, families...)
+	}
+	sortMetricFamilies(all)
+	fmt.Printf("%s=%s\n", automaticTaggedAnnotationKey, buildMetricsInfoAnnotation(all))
+	return nil
+}
+
+func checkPush(podName string, dryRun bool) error {
+	e, err := loadPodEvent(podName)
+	if err != nil {
+		return err
+	}
+	if router, err = newPushRouterFromArgs(); err != nil {
+		return err
+	}
+	c := &scrapeClient{timeout: e.Timeout}
+	for _, t := range e.Targets {
+		families, err := fetchMetrics(c, e, t)
+		if err != nil {
+			return fmt.Errorf("%s: %s", t.String(), err.Error())
+		}
+		data, err := buildPrometheusData(e, t, families, false)
+		if err != nil {
+			return err
+		}
+		if err = checkPushData(data, len(families), dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPushData(data *PrometheusData, count int, dryRun bool) error {
+	dest := router.route(data)
+	if dest == nil {
+		return fmt.Errorf("no push gateway matched, the metrics would be dropped")
+	}
+	if !dryRun {
+		if err := pushDataToGW(dest, data); err != nil {
+			return err
+		}
+		fmt.Printf("Pushed %d metric families to push gateway \"%s\" (%s) successfully.\n", count, dest.Name, groupingPath(data))
+		return nil
+	}
+	req, err := newPushRequest(dest, data)
+	if err != nil {
+		return err
+	}
+	//never print the credentials.
+	if req.Header.Get("Authorization") != "" {
+		req.Header.Set("Authorization", "<redacted>")
+	}
+	dump, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Push gateway: %s\n\n%s\n", dest.Name, dump)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestTarget returns the event & target of a POD whose metrics endpoint is served by the handler.
+func newTestTarget(t *testing.T, handler http.Handler, metricType, config string) (*PODEvent, scrapeTarget) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	if args == nil {
+		args = &CommandLineArgs{}
+	}
+	e := &PODEvent{
+		Pod:        &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}, Status: corev1.PodStatus{PodIP: u.Hostname()}},
+		MetricType: metricType,
+		Config:     config,
+		Scheme:     "http",
+		Timeout:    time.Second,
+	}
+	return e, scrapeTarget{Port: port}
+}
+
+// collectForTest fetches the target with the collector of the event's type, and encodes the families in text format.
+func collectForTest(t *testing.T, e *PODEvent, target scrapeTarget) string {
+	families, err := fetchMetrics(&scrapeClient{timeout: e.Timeout}, e, target)
+	if err != nil {
+		t.Fatalf("failed to collect: %s", err.Error())
+	}
+	return encodeForTest(t, families)
+}
+
+func encodeForTest(t *testing.T, families []*dto.MetricFamily) string {
+	data, err := encodeMetricFamilies(families)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	dryRunActionAnnotate = "annotate"
+	dryRunActionPush     = "push"
+	dryRunActionDelete   = "delete"
+	dryRunActionEvent    = "event"
+	//maximum count of the recent actions kept in memory.
+	dryRunHistorySize = 1024
+)
+
+var (
+	dryRun              *dryRunRecorder
+	dryRunActionCounter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "crystal_bridge_dry_run_actions_total", Help: "Total count of the actions which were recorded instead of being performed in dry-run mode."}, []string{"action"})
+)
+
+// DryRunAction is an action which would have been performed if the bridge were not running in dry-run mode.
+type DryRunAction struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	Namespace   string    `json:"namespace"`
+	Pod         string    `json:"pod"`
+	Destination string    `json:"destination,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Detail      string    `json:"detail,omitempty"`
+}
+
+// DryRunPush is the latest payload which would have been pushed to a grouping key.
+type DryRunPush struct {
+	Time        time.Time `json:"time"`
+	Namespace   string    `json:"namespace"`
+	Pod         string    `json:"pod"`
+	Destination string    `json:"destination"`
+	Path        string    `json:"path"`
+	Source      string    `json:"source,omitempty"`
+	Payload     string    `json:"payload"`
+}
+
+// dryRunRecorder replaces the POD annotation writeback, the push gateways and the Kubernetes events in dry-run mode,
+// so that a new build can be shadow-run alongside the live one.
+type dryRunRecorder struct {
+	lock    sync.Mutex
+	actions []DryRunAction //ring buffer of the recent actions.
+	next    int
+	pushes  map[string]*DryRunPush
+	//the last recorded annotation of every POD, since the annotations in the informer cache are never updated.
+	annotations map[types.UID]string
+}
+
+func initializeDryRun() {
+	if !args.DryRun {
+		return
+	}
+	log.Warnln("Running in DRY-RUN mode, NOTHING will be written to Kubernetes or the push gateways.")
+	prometheus.MustRegister(dryRunActionCounter)
+	dryRun = &dryRunRecorder{actions: make([]DryRunAction, 0, dryRunHistorySize), pushes: make(map[string]*DryRunPush), annotations: make(map[types.UID]string)}
+}
+
+func (r *dryRunRecorder) record(action DryRunAction) {
+	action.Time = time.Now()
+	dryRunActionCounter.WithLabelValues(action.Action).Inc()
+	log.Infof("[DRY-RUN] Would %s, POD: %s/%s, destination: %s, path: %s, detail: %s", action.Action, action.Namespace, action.Pod, action.Destination, action.Path, action.Detail)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.actions) < dryRunHistorySize {
+		r.actions = append(r.actions, action)
+	} else {
+		r.actions[r.next] = action
+	}
+	r.next = (r.next + 1) % dryRunHistorySize
+}
+
+// recordAnnotation records the annotation writeback ONLY if it differs from the last recorded one.
+func (r *dryRunRecorder) recordAnnotation(e *PODEvent) {
+	r.lock.Lock()
+	last, ok := r.annotations[e.Pod.UID]
+	r.annotations[e.Pod.UID] = e.NeededAppendingAnnotation
+	r.lock.Unlock()
+	if ok && last == e.NeededAppendingAnnotation {
+		return
+	}
+	r.record(DryRunAction{Action: dryRunActionAnnotate, Namespace: e.Pod.Namespace, Pod: e.Pod.Name, Detail: automaticTaggedAnnotationKey + "=" + e.NeededAppendingAnnotation})
+}
+
+func (r *dryRunRecorder) recordPush(dest *pushDestination, data *PrometheusData) {
+	r.recordPushTo(dest, data, groupingPath(data))
+}
+
+// recordPushTo records the push to given grouping key rather than the POD's own one.
+func (r *dryRunRecorder) recordPushTo(dest *pushDestination, data *PrometheusData, path string) {
+	r.record(DryRunAction{Action: dryRunActionPush, Namespace: data.Namespace, Pod: data.PodName, Destination: dest.Name, Path: path, Detail: dest.URL + path})
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.pushes[dest.Name+path+"#"+data.Source] = &DryRunPush{Time: time.Now(), Namespace: data.Namespace, Pod: data.PodName, Destination: dest.Name, Path: path, Source: data.Source, Payload: string(data.RspData)}
+}
+
+func (r *dryRunRecorder) recordDelete(dest *pushDestination, data *PrometheusData) {
+	r.recordDeleteTo(dest, data, groupingPath(data))
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.annotations, data.PodUID)
+}
+
+func (r *dryRunRecorder) recordDeleteTo(dest *pushDestination, data *PrometheusData, path string) {
+	r.record(DryRunAction{Action: dryRunActionDelete, Namespace: data.Namespace, Pod: data.PodName, Destination: dest.Name, Path: path, Detail: dest.URL + path})
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, push := range r.pushes {
+		if push.Destination == dest.Name && push.Path == path {
+			delete(r.pushes, key)
+		}
+	}
+}
+
+// listActions returns the recent actions in chronological order.
+func (r *dryRunRecorder) listActions() []DryRunAction {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.actions) < dryRunHistorySize {
+		return append([]DryRunAction{}, r.actions...)
+	}
+	return append(append([]DryRunAction{}, r.actions[r.next:]...), r.actions[:r.next]...)
+}
+
+// listPushes returns the latest payload of every grouping key which has not been deleted, sorted by destination and path.
+func (r *dryRunRecorder) listPushes() []DryRunPush {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pushes := make([]DryRunPush, 0, len(r.pushes))
+	for _, v := range r.pushes {
+		pushes = append(pushes, *v)
+	}
+	sort.Slice(pushes, func(i, j int) bool {
+		if pushes[i].Destination != pushes[j].Destination {
+			return pushes[i].Destination < pushes[j].Destination
+		}
+		return pushes[i].Path < pushes[j].Path
+	})
+	return pushes
+}
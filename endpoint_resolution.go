@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	//the endpoint will be derived from the container port with this name if no endpoint is annotated.
+	defaultMetricsPortName = "metrics"
+	//suffix of the per-container annotations, e.g. io.collectbeat.metrics/sidecar.endpoints
+	containerEndpointsSuffix = ".endpoints"
+)
+
+var (
+	//IANA_SVC_NAME, the same as the rule of Kubernetes container port names.
+	portNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+)
+
+// scrapeTarget is an endpoint of the POD which has been resolved against its container ports.
+type scrapeTarget struct {
+	Name      string //container name labelled on the pushed metrics, empty for the POD level endpoint.
+	Container string //container which owns the port, empty if no container declares it.
+	Port      int
+	Path      string //empty if not given, the default path of the metrics type will be used.
+	Named     bool   //the port is resolved by its name.
+}
+
+func (t scrapeTarget) String() string {
+	if t.Name == "" {
+		return fmt.Sprintf(":%d%s", t.Port, t.Path)
+	}
+	return fmt.Sprintf("%s=:%d%s", t.Name, t.Port, t.Path)
+}
+
+// URL returns the address of the target on the given host.
+func (t scrapeTarget) URL(scheme, host string) string {
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(t.Port)), t.Path)
+}
+
+// parseEndpoint splits an endpoint formatted as ":port/path", "name/path" or "@name/path" into its port and path.
+func parseEndpoint(endpoint string) (string, string, error) {
+	if endpoint == "" {
+		return "", "", fmt.Errorf("endpoint CANNOT be empty")
+	}
+	//m.Event.Endpoints support ONLY one address by now.
+	if strings.Contains(endpoint, ",") {
+		return "", "", fmt.Errorf("ONLY one endpoint is supported")
+	}
+	port, path := strings.TrimPrefix(strings.TrimPrefix(endpoint, "@"), ":"), ""
+	if idx := strings.Index(port, "/"); idx >= 0 {
+		port, path = port[:idx], port[idx:]
+	}
+	if port == "" {
+		return "", "", fmt.Errorf("endpoint must be formatted as \":port/path\", \"name/path\" or \"@name/path\"")
+	}
+	if _, err := strconv.Atoi(port); err == nil {
+		if err = validatePort(port); err != nil {
+			return "", "", err
+		}
+	} else if len(port) > 15 || !portNameRegexp.MatchString(port) {
+		return "", "", fmt.Errorf("invalid port name \"%s\"", port)
+	}
+	if err := validatePath(path); err != nil {
+		return "", "", err
+	}
+	return port, path, nil
+}
+
+// resolveEndpoint resolves the endpoint against the ports of the given container, or all containers if it is empty.
+func resolveEndpoint(pod *corev1.Pod, container, endpoint string) (scrapeTarget, error) {
+	port, path, err := parseEndpoint(endpoint)
+	if err != nil {
+		return scrapeTarget{}, err
+	}
+	if number, err := strconv.Atoi(port); err == nil {
+		owner := container
+		if owner == "" {
+			owner = containerOwningPort(pod, number)
+		}
+		return scrapeTarget{Name: container, Container: owner, Port: number, Path: path}, nil
+	}
+	for _, c := range pod.Spec.Containers {
+		if container != "" && c.Name != container {
+			continue
+		}
+		for _, p := range c.Ports {
+			if p.Name == port {
+				return scrapeTarget{Name: container, Container: c.Name, Port: int(p.ContainerPort), Path: path, Named: true}, nil
+			}
+		}
+	}
+	if container != "" {
+		return scrapeTarget{}, fmt.Errorf("container \"%s\" has no port named \"%s\"", container, port)
+	}
+	return scrapeTarget{}, fmt.Errorf("no container port named \"%s\"", port)
+}
+
+// resolveTargets resolves the POD level endpoint, the per-container endpoints,
+// or derives the endpoints from the container ports named "metrics" if nothing is annotated.
+func (e *PODEvent) resolveTargets() {
+	e.Targets = nil
+	annotated := false
+	if eps, ok := e.annotation("endpoints"); ok {
+		annotated = true
+		e.Endpoints = eps
+		if t, err := resolveEndpoint(e.Pod, "", eps); err != nil {
+			e.addError("invalid endpoints \"%s\": %s", eps, err.Error())
+		} else {
+			e.Targets = append(e.Targets, t)
+		}
+	}
+	containers := map[string]bool{}
+	for _, c := range e.Pod.Spec.Containers {
+		containers[c.Name] = true
+	}
+	prefix := args.AnnotationPrefixTag + "/"
+	keys := []string{}
+	for k := range e.Pod.Annotations {
+		if strings.HasPrefix(k, prefix) && strings.HasSuffix(k, containerEndpointsSuffix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		annotated = true
+		container := strings.TrimSuffix(strings.TrimPrefix(k, prefix), containerEndpointsSuffix)
+		eps := strings.TrimSpace(e.Pod.Annotations[k])
+		if !containers[container] {
+			e.addError("annotation \"%s\" refers to an unknown container \"%s\"", k, container)
+			continue
+		}
+		if t, err := resolveEndpoint(e.Pod, container, eps); err != nil {
+			e.addError("invalid endpoints \"%s\" of container \"%s\": %s", eps, container, err.Error())
+		} else {
+			e.Targets = append(e.Targets, t)
+		}
+	}
+	if annotated {
+		return
+	}
+	for _, c := range e.Pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == defaultMetricsPortName {
+				e.Targets = append(e.Targets, scrapeTarget{Name: c.Name, Container: c.Name, Port: int(p.ContainerPort), Named: true})
+			}
+		}
+	}
+	switch len(e.Targets) {
+	case 0:
+		e.addError("annotation \"%s/endpoints\" is required since no container port is named \"%s\"", args.AnnotationPrefixTag, defaultMetricsPortName)
+	case 1:
+		//the only target is pushed under the POD level grouping key, like an annotated endpoint.
+		e.Targets[0].Name = ""
+	}
+}
+
+func sameTargets(a, b []scrapeTarget) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
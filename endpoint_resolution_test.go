@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestResolveMetricsPortTargets(t *testing.T) {
+	if args == nil {
+		args = &CommandLineArgs{AnnotationPrefixTag: "io.collectbeat.metrics"}
+	}
+	container := func(name string, port int32) corev1.Container {
+		return corev1.Container{Name: name, Ports: []corev1.ContainerPort{{Name: defaultMetricsPortName, ContainerPort: port}}}
+	}
+	e := &PODEvent{Pod: &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{container("app", 8080), {Name: "proxy"}}}}}
+	e.resolveTargets()
+	if len(e.Targets) != 1 || e.Targets[0].Name != "" || e.Targets[0].Container != "app" || e.Targets[0].Port != 8080 {
+		t.Errorf("unexpected targets of single metrics port: %+v", e.Targets)
+	}
+	e = &PODEvent{Pod: &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{container("app", 8080), container("sidecar", 9090)}}}}
+	e.resolveTargets()
+	if len(e.Targets) != 2 || e.Targets[0].Name != "app" || e.Targets[1].Name != "sidecar" {
+		t.Errorf("unexpected targets of multiple metrics ports: %+v", e.Targets)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/flowcontrol"
+	"regexp"
+	"time"
+)
+
+const (
+	eventReasonScrapeFailed      = "MetricsScrapeFailed"
+	eventReasonInvalidAnnotation = "InvalidMetricsAnnotation"
+	eventReasonPushFailed        = "MetricsPushFailed"
+	eventSourceComponent         = "crystal-bridge"
+	//the same event will be aggregated into the previous one within this window.
+	eventAggregationWindow = 10 * time.Minute
+	//the count of an aggregated event will be written back at most once per interval.
+	eventRefreshInterval = time.Minute
+)
+
+var (
+	recorder *podEventRecorder
+	//the addresses (with the ephemeral ports) in the messages of the dial errors differ on every failure.
+	eventAddressPattern = regexp.MustCompile(`\[[0-9a-fA-F:.%]+\](:\d+)?|\b\d{1,3}(\.\d{1,3}){3}(:\d+)?`)
+)
+
+// podEventRecorder records deduplicated & rate limited Kubernetes Events against PODs,
+// so that "kubectl describe pod" explains why the metrics are missing.
+type podEventRecorder struct {
+	queue   chan *podEventRecord
+	limiter flowcontrol.RateLimiter
+	events  map[string]*aggregatedEvent //ONLY accessed from the goroutine which consumes the queue.
+}
+
+type podEventRecord struct {
+	Ref     *corev1.ObjectReference
+	Reason  string
+	Message string
+}
+
+type aggregatedEvent struct {
+	Event      *corev1.Event
+	Pending    int32  //occurrences which have not been written back yet.
+	Message    string //message of the latest occurrence.
+	LastUpdate time.Time
+}
+
+func initializeEventRecorder() {
+	if !args.EnableEvents {
+		return
+	}
+	log.Infoln("Initializing Kubernetes event recorder...")
+	recorder = &podEventRecorder{
+		queue:   make(chan *podEventRecord, 256),
+		limiter: flowcontrol.NewTokenBucketRateLimiter(float32(args.EventQPS), args.EventBurst),
+		events:  make(map[string]*aggregatedEvent)}
+	go recorder.run()
+}
+
+func podReference(pod *corev1.Pod) *corev1.ObjectReference {
+	return &corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+}
+
+func podReferenceOf(namespace, name string, uid types.UID) *corev1.ObjectReference {
+	return &corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: namespace, Name: name, UID: uid}
+}
+
+// Warningf never blocks the caller, the event will be dropped if the queue is full.
+func (r *podEventRecorder) Warningf(ref *corev1.ObjectReference, reason, format string, args ...interface{}) {
+	if r == nil || ref == nil {
+		return
+	}
+	if dryRun != nil {
+		dryRun.record(DryRunAction{Action: dryRunActionEvent, Namespace: ref.Namespace, Pod: ref.Name, Detail: reason + ": " + fmt.Sprintf(format, args...)})
+		return
+	}
+	select {
+	case r.queue <- &podEventRecord{Ref: ref, Reason: reason, Message: fmt.Sprintf(format, args...)}:
+	default:
+		log.Debugf("Dropped Kubernetes event %s for POD: %s since the queue is full.", reason, ref.Name)
+	}
+}
+
+func (r *podEventRecorder) run() {
+	ticker := time.NewTicker(eventRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case record := <-r.queue:
+			r.record(record)
+		case now := <-ticker.C:
+			r.purge(now)
+		}
+	}
+}
+
+// eventKey identifies the aggregated event, the addresses in the message are ignored.
+func eventKey(record *podEventRecord) string {
+	return string(record.Ref.UID) + "/" + record.Reason + "/" + eventAddressPattern.ReplaceAllString(record.Message, "<address>")
+}
+
+func (r *podEventRecorder) record(record *podEventRecord) {
+	key := eventKey(record)
+	now := time.Now()
+	aggregated, ok := r.events[key]
+	if ok {
+		aggregated.Pending++
+		aggregated.Message = record.Message
+		if now.Sub(aggregated.LastUpdate) < eventRefreshInterval {
+			return
+		}
+	}
+	if !r.limiter.TryAccept() {
+		log.Debugf("Kubernetes event %s for POD: %s has been rate limited.", record.Reason, record.Ref.Name)
+		return
+	}
+	if ok {
+		r.update(aggregated, now)
+		return
+	}
+	event := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: fmt.Sprintf("%s.%x", record.Ref.Name, now.UnixNano()), Namespace: record.Ref.Namespace},
+		InvolvedObject: *record.Ref,
+		Reason:         record.Reason,
+		Message:        record.Message,
+		FirstTimestamp: metav1.NewTime(now),
+		LastTimestamp:  metav1.NewTime(now),
+		Count:          1,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: eventSourceComponent, Host: args.Host}}
+	created, err := k8sClient.CoreV1().Events(event.Namespace).Create(event)
+	if err != nil {
+		log.Errorf("Failed to create Kubernetes event %s for POD: %s, error: %s", record.Reason, record.Ref.Name, err.Error())
+		return
+	}
+	r.events[key] = &aggregatedEvent{Event: created, Message: record.Message, LastUpdate: now}
+}
+
+// update writes the pending occurrences & the latest message back to the event.
+func (r *podEventRecorder) update(aggregated *aggregatedEvent, now time.Time) {
+	event := aggregated.Event.DeepCopy()
+	event.Count += aggregated.Pending
+	event.Message = aggregated.Message
+	event.LastTimestamp = metav1.NewTime(now)
+	updated, err := k8sClient.CoreV1().Events(event.Namespace).Update(event)
+	if err != nil {
+		log.Errorf("Failed to update Kubernetes event %s for POD: %s, error: %s", event.Reason, event.InvolvedObject.Name, err.Error())
+		return
+	}
+	aggregated.Event = updated
+	aggregated.Pending = 0
+	aggregated.LastUpdate = now
+}
+
+// purge writes back the pending occurrences which have waited for the refresh interval, and forgets the events
+// which are out of the aggregation window after their pending occurrences have been written back.
+func (r *podEventRecorder) purge(now time.Time) {
+	for k, v := range r.events {
+		expired := now.Sub(v.Event.FirstTimestamp.Time) > eventAggregationWindow
+		if v.Pending > 0 && (expired || (now.Sub(v.LastUpdate) >= eventRefreshInterval && r.limiter.TryAccept())) {
+			r.update(v, now)
+		}
+		if expired {
+			delete(r.events, k)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestEventKey(t *testing.T) {
+	ref := &corev1.ObjectReference{UID: "uid-1"}
+	same := []string{
+		"Failed to scrape metrics from :8080/metrics: Get http://10.0.0.5:8080/metrics: read tcp 10.0.1.2:54321->10.0.0.5:8080: read: connection reset by peer",
+		"Failed to scrape metrics from :8080/metrics: Get http://10.0.0.5:8080/metrics: read tcp 10.0.1.2:40112->10.0.0.5:8080: read: connection reset by peer",
+		"Failed to scrape metrics from :8080/metrics: Get http://10.0.0.7:8080/metrics: read tcp [fd00::2]:40112->10.0.0.7:8080: read: connection reset by peer",
+	}
+	key := eventKey(&podEventRecord{Ref: ref, Reason: eventReasonScrapeFailed, Message: same[0]})
+	for _, message := range same[1:] {
+		if k := eventKey(&podEventRecord{Ref: ref, Reason: eventReasonScrapeFailed, Message: message}); k != key {
+			t.Errorf("unexpected key: %s, want: %s", k, key)
+		}
+	}
+	different := eventKey(&podEventRecord{Ref: ref, Reason: eventReasonScrapeFailed, Message: "Failed to scrape metrics from :8080/metrics: HTTP response status code: 500"})
+	if different == key {
+		t.Errorf("different failures share the key: %s", key)
+	}
+}
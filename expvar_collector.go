@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	"strings"
+)
+
+const (
+	defaultExpvarPrefix = "expvar"
+	expvarMemStatsKey   = "memstats"
+)
+
+// expvarMemStats maps the fields of "runtime.MemStats" to the names used by the Prometheus Go collector,
+// the other fields of "memstats" are dropped.
+var expvarMemStats = map[string]struct {
+	Name  string
+	Type  dto.MetricType
+	Scale float64
+}{
+	"Alloc":         {"go_memstats_alloc_bytes", dto.MetricType_GAUGE, 1},
+	"TotalAlloc":    {"go_memstats_alloc_bytes_total", dto.MetricType_COUNTER, 1},
+	"Sys":           {"go_memstats_sys_bytes", dto.MetricType_GAUGE, 1},
+	"Lookups":       {"go_memstats_lookups_total", dto.MetricType_COUNTER, 1},
+	"Mallocs":       {"go_memstats_mallocs_total", dto.MetricType_COUNTER, 1},
+	"Frees":         {"go_memstats_frees_total", dto.MetricType_COUNTER, 1},
+	"HeapAlloc":     {"go_memstats_heap_alloc_bytes", dto.MetricType_GAUGE, 1},
+	"HeapSys":       {"go_memstats_heap_sys_bytes", dto.MetricType_GAUGE, 1},
+	"HeapIdle":      {"go_memstats_heap_idle_bytes", dto.MetricType_GAUGE, 1},
+	"HeapInuse":     {"go_memstats_heap_inuse_bytes", dto.MetricType_GAUGE, 1},
+	"HeapReleased":  {"go_memstats_heap_released_bytes", dto.MetricType_GAUGE, 1},
+	"HeapObjects":   {"go_memstats_heap_objects", dto.MetricType_GAUGE, 1},
+	"StackInuse":    {"go_memstats_stack_inuse_bytes", dto.MetricType_GAUGE, 1},
+	"StackSys":      {"go_memstats_stack_sys_bytes", dto.MetricType_GAUGE, 1},
+	"MSpanInuse":    {"go_memstats_mspan_inuse_bytes", dto.MetricType_GAUGE, 1},
+	"MSpanSys":      {"go_memstats_mspan_sys_bytes", dto.MetricType_GAUGE, 1},
+	"MCacheInuse":   {"go_memstats_mcache_inuse_bytes", dto.MetricType_GAUGE, 1},
+	"MCacheSys":     {"go_memstats_mcache_sys_bytes", dto.MetricType_GAUGE, 1},
+	"BuckHashSys":   {"go_memstats_buck_hash_sys_bytes", dto.MetricType_GAUGE, 1},
+	"GCSys":         {"go_memstats_gc_sys_bytes", dto.MetricType_GAUGE, 1},
+	"OtherSys":      {"go_memstats_other_sys_bytes", dto.MetricType_GAUGE, 1},
+	"NextGC":        {"go_memstats_next_gc_bytes", dto.MetricType_GAUGE, 1},
+	"LastGC":        {"go_memstats_last_gc_time_seconds", dto.MetricType_GAUGE, 1e-9},
+	"GCCPUFraction": {"go_memstats_gc_cpu_fraction", dto.MetricType_GAUGE, 1},
+}
+
+// expvarConfig is the optional configuration of the "expvar" type, e.g.
+//
+//	prefix: myapp
+//	counters: [requests, http.hits]
+type expvarConfig struct {
+	Prefix string `yaml:"prefix"`
+	// Counters are the dot separated keys of the variables which are counters, every leaf under a counter map is a counter as well.
+	Counters []string `yaml:"counters"`
+}
+
+func validateExpvarConfig(config []byte) error {
+	_, err := parseExpvarConfig(config)
+	return err
+}
+
+func parseExpvarConfig(config []byte) (*expvarConfig, error) {
+	cfg := &expvarConfig{}
+	if err := yaml.UnmarshalStrict(config, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultExpvarPrefix
+	}
+	if !model.IsValidMetricName(model.LabelValue(cfg.Prefix)) {
+		return nil, fmt.Errorf("invalid prefix \"%s\"", cfg.Prefix)
+	}
+	for i, key := range cfg.Counters {
+		if key == "" || strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".") {
+			return nil, fmt.Errorf("counters[%d]: invalid key \"%s\"", i, key)
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *expvarConfig) isCounter(key string) bool {
+	for _, counter := range cfg.Counters {
+		if key == counter || strings.HasPrefix(key, counter+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func collectExpvar(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error) {
+	config, err := loadTypeConfig(e)
+	if err != nil {
+		return nil, err
+	}
+	//the configuration is optional, the empty one gives the defaults.
+	cfg, err := parseExpvarConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expvar config: %s", err.Error())
+	}
+	body, err := getBody(c, e, t, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]interface{}{}
+	if err = json.Unmarshal(body, &vars); err != nil {
+		return nil, fmt.Errorf("invalid expvar document: %s", err.Error())
+	}
+	return cfg.convert(vars, e.Limits)
+}
+
+// convert flattens the numeric leaves of the variables into gauges, e.g. "http.hits" -> "expvar_http_hits",
+// the strings & arrays are skipped.
+func (cfg *expvarConfig) convert(vars map[string]interface{}, limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	builder := newFamilyBuilder()
+	//the keys are walked in order, so that the same variable wins if several keys are sanitized into the same name.
+	for _, key := range sortedKeys(vars) {
+		value := vars[key]
+		if key == expvarMemStatsKey {
+			if memstats, ok := value.(map[string]interface{}); ok {
+				convertMemStats(builder, memstats)
+				continue
+			}
+		}
+		cfg.flatten(builder, key, value)
+	}
+	return builder.build(limits)
+}
+
+func (cfg *expvarConfig) flatten(builder *familyBuilder, key string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(v) {
+			cfg.flatten(builder, key+"."+k, v[k])
+		}
+	case float64, bool:
+		f, _ := jsonNumber(v)
+		metricType := dto.MetricType_GAUGE
+		if cfg.isCounter(key) {
+			metricType = dto.MetricType_COUNTER
+		}
+		builder.add(cfg.Prefix+"_"+sanitizeMetricName(key), "", metricType, f, nil)
+	}
+}
+
+func convertMemStats(builder *familyBuilder, memstats map[string]interface{}) {
+	for _, field := range sortedKeys(memstats) {
+		mapping, ok := expvarMemStats[field]
+		if !ok {
+			continue
+		}
+		if f, ok := memstats[field].(float64); ok {
+			builder.add(mapping.Name, "", mapping.Type, f*mapping.Scale, nil)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExpvarConvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		vars   string
+		want   string
+	}{
+		{
+			name: "nested maps",
+			vars: `{"cmdline": ["app"], "version": "1.0", "ready": true, "http": {"hits": 3, "codes": {"200": 2, "500": 1}}}`,
+			want: `# TYPE expvar_http_codes_200 gauge
+expvar_http_codes_200 2
+# TYPE expvar_http_codes_500 gauge
+expvar_http_codes_500 1
+# TYPE expvar_http_hits gauge
+expvar_http_hits 3
+# TYPE expvar_ready gauge
+expvar_ready 1
+`,
+		},
+		{
+			name:   "counter keys",
+			config: "prefix: myapp\ncounters: [requests, http]\n",
+			vars:   `{"requests": 10, "requests_inflight": 2, "http": {"hits": 3, "misses": 1}}`,
+			want: `# TYPE myapp_http_hits counter
+myapp_http_hits 3
+# TYPE myapp_http_misses counter
+myapp_http_misses 1
+# TYPE myapp_requests counter
+myapp_requests 10
+# TYPE myapp_requests_inflight gauge
+myapp_requests_inflight 2
+`,
+		},
+		{
+			name: "memstats",
+			vars: `{"memstats": {"Alloc": 100, "TotalAlloc": 300, "LastGC": 1500000000000000000, "NumGC": 4, "PauseNs": [1, 2]}}`,
+			want: `# TYPE go_memstats_alloc_bytes gauge
+go_memstats_alloc_bytes 100
+# TYPE go_memstats_alloc_bytes_total counter
+go_memstats_alloc_bytes_total 300
+# TYPE go_memstats_last_gc_time_seconds gauge
+go_memstats_last_gc_time_seconds 1.5e+09
+`,
+		},
+		{
+			name: "conflicting names",
+			vars: `{"a.b": 1, "a": {"b": 2}, "a_b": 3}`,
+			want: `# TYPE expvar_a_b gauge
+expvar_a_b 2
+`,
+		},
+	}
+	for _, test := range tests {
+		cfg, err := parseExpvarConfig([]byte(test.config))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		vars := map[string]interface{}{}
+		if err = json.Unmarshal([]byte(test.vars), &vars); err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		for i := 0; i < 10; i++ {
+			families, err := cfg.convert(vars, scrapeLimits{})
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err.Error())
+			}
+			if got := encodeForTest(t, families); got != test.want {
+				t.Errorf("%s: unexpected metrics:\n%s\nwant:\n%s", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseExpvarConfig(t *testing.T) {
+	for _, config := range []string{"prefix: 1abc\n", "counters: [.a]\n", "counters: [a.]\n", "unknown: 1\n"} {
+		if _, err := parseExpvarConfig([]byte(config)); err == nil {
+			t.Errorf("invalid config accepted: %q", config)
+		}
+	}
+}
+
+func TestCollectExpvar(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/vars" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"goroutines": 12}`))
+	})
+	e, target := newTestTarget(t, handler, "expvar", "")
+	if got := collectForTest(t, e, target); !strings.Contains(got, "expvar_goroutines 12\n") {
+		t.Errorf("unexpected metrics:\n%s", got)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+	"sync"
+)
+
+const (
+	//scrape the hostNetwork POD via the IP of the node.
+	hostNetworkAddressHost = "host"
+	//scrape the hostNetwork POD via the loopback address, for the node agents which ONLY bind to localhost.
+	//crystal-bridge itself must run with hostNetwork in this case.
+	hostNetworkAddressLocalhost = "localhost"
+	localhostIP                 = "127.0.0.1"
+)
+
+var (
+	//the owner of every node-local address, so that the PODs sharing the node's network are scraped ONLY once.
+	hostNetworkTargets     = make(map[string]types.UID)
+	hostNetworkTargetsLock = &sync.Mutex{}
+)
+
+func isSupportedHostNetworkAddress(address string) bool {
+	return address == hostNetworkAddressHost || address == hostNetworkAddressLocalhost
+}
+
+// scrapeHost returns the address to be scraped, which is the node's (or the loopback) address for the hostNetwork PODs.
+func scrapeHost(e *PODEvent) string {
+	if !e.Pod.Spec.HostNetwork {
+		return e.Pod.Status.PodIP
+	}
+	if e.HostNetworkAddress == hostNetworkAddressLocalhost {
+		return localhostIP
+	}
+	if e.Pod.Status.HostIP != "" {
+		return e.Pod.Status.HostIP
+	}
+	return e.Pod.Status.PodIP
+}
+
+// claimHostNetworkTarget returns true if the URL is owned by the POD, otherwise the owner is returned.
+func claimHostNetworkTarget(url string, uid types.UID) (types.UID, bool) {
+	hostNetworkTargetsLock.Lock()
+	defer hostNetworkTargetsLock.Unlock()
+	owner, ok := hostNetworkTargets[url]
+	if !ok {
+		hostNetworkTargets[url] = uid
+		return uid, true
+	}
+	return owner, owner == uid
+}
+
+func releaseHostNetworkTargets(uid types.UID) {
+	hostNetworkTargetsLock.Lock()
+	defer hostNetworkTargetsLock.Unlock()
+	for url, owner := range hostNetworkTargets {
+		if owner == uid {
+			delete(hostNetworkTargets, url)
+		}
+	}
+}
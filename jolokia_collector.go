@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultJolokiaPrefix = "jolokia"
+)
+
+// jolokiaConfig describes the MBeans to be read through the Jolokia agent, e.g.
+//
+//	mbeans:
+//	- mbean: java.lang:type=Memory
+//	  attributes: [HeapMemoryUsage, NonHeapMemoryUsage]
+//	- mbean: java.lang:type=GarbageCollector,name=*
+//	  attributes: [CollectionCount, CollectionTime]
+//	  counters: [CollectionCount, CollectionTime]
+type jolokiaConfig struct {
+	Prefix string          `yaml:"prefix"`
+	MBeans []*jolokiaMBean `yaml:"mbeans"`
+}
+
+// jolokiaMBean is an MBean (pattern) whose attributes will be read, all attributes will be read if none is given.
+type jolokiaMBean struct {
+	MBean      string   `yaml:"mbean"`
+	Attributes []string `yaml:"attributes"`
+	Counters   []string `yaml:"counters"`
+}
+
+type jolokiaRequest struct {
+	Type      string   `json:"type"`
+	MBean     string   `json:"mbean"`
+	Attribute []string `json:"attribute,omitempty"`
+}
+
+type jolokiaResponse struct {
+	Status int         `json:"status"`
+	Error  string      `json:"error"`
+	Value  interface{} `json:"value"`
+}
+
+func validateJolokiaConfig(config []byte) error {
+	_, err := parseJolokiaConfig(config)
+	return err
+}
+
+func parseJolokiaConfig(config []byte) (*jolokiaConfig, error) {
+	cfg := &jolokiaConfig{}
+	if err := yaml.UnmarshalStrict(config, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultJolokiaPrefix
+	}
+	if !model.IsValidMetricName(model.LabelValue(cfg.Prefix)) {
+		return nil, fmt.Errorf("invalid prefix \"%s\"", cfg.Prefix)
+	}
+	if len(cfg.MBeans) == 0 {
+		return nil, fmt.Errorf("no MBean is defined")
+	}
+	for i, mbean := range cfg.MBeans {
+		if _, _, err := parseObjectName(mbean.MBean); err != nil {
+			return nil, fmt.Errorf("mbeans[%d]: %s", i, err.Error())
+		}
+	}
+	return cfg, nil
+}
+
+// parseObjectName splits a JMX object name formatted as "domain:key=value,key=value" into its domain and key properties.
+func parseObjectName(name string) (string, map[string]string, error) {
+	idx := strings.Index(name, ":")
+	if idx <= 0 || idx == len(name)-1 {
+		return "", nil, fmt.Errorf("invalid MBean name \"%s\", it must be formatted as \"domain:key=value\"", name)
+	}
+	properties := map[string]string{}
+	inQuotes := false
+	start := idx + 1
+	for i := start; i <= len(name); i++ {
+		if i < len(name) && name[i] == '"' {
+			inQuotes = !inQuotes
+		}
+		if i < len(name) && (name[i] != ',' || inQuotes) {
+			continue
+		}
+		pair := name[start:i]
+		start = i + 1
+		if pair == "*" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return "", nil, fmt.Errorf("invalid key property \"%s\" of MBean \"%s\"", pair, name)
+		}
+		properties[kv[0]] = strings.Trim(kv[1], "\"")
+	}
+	return name[:idx], properties, nil
+}
+
+// snakeCase converts the attribute names like "HeapMemoryUsage" into "heap_memory_usage".
+func snakeCase(name string) string {
+	runes := []rune(name)
+	sb := strings.Builder{}
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				sb.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sanitizeMetricName(sb.String())
+}
+
+func collectJolokia(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error) {
+	config, err := loadTypeConfig(e)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseJolokiaConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Jolokia config: %s", err.Error())
+	}
+	requests := make([]jolokiaRequest, 0, len(cfg.MBeans))
+	for _, mbean := range cfg.MBeans {
+		requests = append(requests, jolokiaRequest{Type: "read", MBean: mbean.MBean, Attribute: mbean.Attributes})
+	}
+	payload, err := json.Marshal(requests)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", t.URL(e.Scheme, scrapeHost(e)), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	body, err := fetchBody(c, e, req)
+	if err != nil {
+		return nil, err
+	}
+	responses := []jolokiaResponse{}
+	if err = json.Unmarshal(body, &responses); err != nil {
+		return nil, fmt.Errorf("invalid Jolokia response: %s", err.Error())
+	}
+	if len(responses) != len(cfg.MBeans) {
+		return nil, fmt.Errorf("Jolokia returned %d responses for %d requests", len(responses), len(cfg.MBeans))
+	}
+	builder := newFamilyBuilder()
+	failed := 0
+	for i, rsp := range responses {
+		mbean := cfg.MBeans[i]
+		if rsp.Status != http.StatusOK {
+			failed++
+			log.Debugf("Failed to read MBean %s of POD: %s, status: %d, error: %s", mbean.MBean, e.Pod.Name, rsp.Status, rsp.Error)
+			continue
+		}
+		cfg.convert(builder, mbean, rsp.Value)
+	}
+	if failed == len(responses) {
+		return nil, fmt.Errorf("failed to read all %d MBeans", failed)
+	}
+	return builder.build(e.Limits)
+}
+
+// convert maps the value of a read response, which is keyed by the MBean names if the MBean is a pattern.
+func (cfg *jolokiaConfig) convert(builder *familyBuilder, mbean *jolokiaMBean, value interface{}) {
+	if !strings.Contains(mbean.MBean, "*") && !strings.Contains(mbean.MBean, "?") {
+		cfg.convertMBean(builder, mbean, mbean.MBean, value)
+		return
+	}
+	byName, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, name := range sortedKeys(byName) {
+		cfg.convertMBean(builder, mbean, name, byName[name])
+	}
+}
+
+func (cfg *jolokiaConfig) convertMBean(builder *familyBuilder, mbean *jolokiaMBean, name string, value interface{}) {
+	domain, properties, err := parseObjectName(name)
+	if err != nil {
+		log.Debugf("Skipped MBean with invalid name: %s", name)
+		return
+	}
+	labels := map[string]string{"domain": domain}
+	for k, v := range properties {
+		labels[sanitizeLabelName(k)] = v
+	}
+	attributes, ok := value.(map[string]interface{})
+	if !ok {
+		//a single attribute was requested.
+		if len(mbean.Attributes) != 1 {
+			return
+		}
+		attributes = map[string]interface{}{mbean.Attributes[0]: value}
+	}
+	counters := map[string]bool{}
+	for _, c := range mbean.Counters {
+		counters[c] = true
+	}
+	for _, attribute := range sortedKeys(attributes) {
+		v := attributes[attribute]
+		metricType := dto.MetricType_GAUGE
+		if counters[attribute] {
+			metricType = dto.MetricType_COUNTER
+		}
+		cfg.flatten(builder, cfg.Prefix+"_"+snakeCase(attribute), metricType, v, labels)
+	}
+}
+
+// flatten maps the numeric leaves of the composite attributes, e.g. "HeapMemoryUsage.used" -> "jolokia_heap_memory_usage_used".
+func (cfg *jolokiaConfig) flatten(builder *familyBuilder, name string, metricType dto.MetricType, value interface{}, labels map[string]string) {
+	if composite, ok := value.(map[string]interface{}); ok {
+		for _, k := range sortedKeys(composite) {
+			cfg.flatten(builder, name+"_"+snakeCase(k), metricType, composite[k], labels)
+		}
+		return
+	}
+	if f, ok := jsonNumber(value); ok {
+		builder.add(name, "", metricType, f, labels)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const jolokiaTestConfig = `
+mbeans:
+- mbean: java.lang:type=Memory
+  attributes: [HeapMemoryUsage]
+- mbean: java.lang:type=GarbageCollector,name=*
+  attributes: [CollectionCount, CollectionTime]
+  counters: [CollectionCount]
+- mbean: java.lang:type=Threading
+  attributes: [ThreadCount]
+- mbean: java.lang:type=Missing
+`
+
+// jolokiaTestHandler replies the bulk read of jolokiaTestConfig like a Jolokia agent.
+func jolokiaTestHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/jolokia" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		requests := []jolokiaRequest{}
+		if err := json.Unmarshal(body, &requests); err != nil || len(requests) != 4 || requests[0].Type != "read" {
+			t.Errorf("unexpected bulk request: %s", body)
+		}
+		w.Write([]byte(`[
+{"status": 200, "value": {"HeapMemoryUsage": {"init": 1024, "used": 512, "committed": 2048, "max": -1}}},
+{"status": 200, "value": {
+	"java.lang:name=PS Scavenge,type=GarbageCollector": {"CollectionCount": 7, "CollectionTime": 30},
+	"java.lang:name=PS MarkSweep,type=GarbageCollector": {"CollectionCount": 1, "CollectionTime": 90}}},
+{"status": 200, "value": 42},
+{"status": 404, "error": "javax.management.InstanceNotFoundException: java.lang:type=Missing"}
+]`))
+	})
+}
+
+func TestCollectJolokia(t *testing.T) {
+	e, target := newTestTarget(t, jolokiaTestHandler(t), "jolokia", jolokiaTestConfig)
+	got := collectForTest(t, e, target)
+	want := `# TYPE jolokia_collection_count counter
+jolokia_collection_count{domain="java.lang",name="PS MarkSweep",type="GarbageCollector"} 1
+jolokia_collection_count{domain="java.lang",name="PS Scavenge",type="GarbageCollector"} 7
+# TYPE jolokia_collection_time gauge
+jolokia_collection_time{domain="java.lang",name="PS MarkSweep",type="GarbageCollector"} 90
+jolokia_collection_time{domain="java.lang",name="PS Scavenge",type="GarbageCollector"} 30
+# TYPE jolokia_heap_memory_usage_committed gauge
+jolokia_heap_memory_usage_committed{domain="java.lang",type="Memory"} 2048
+# TYPE jolokia_heap_memory_usage_init gauge
+jolokia_heap_memory_usage_init{domain="java.lang",type="Memory"} 1024
+# TYPE jolokia_heap_memory_usage_max gauge
+jolokia_heap_memory_usage_max{domain="java.lang",type="Memory"} -1
+# TYPE jolokia_heap_memory_usage_used gauge
+jolokia_heap_memory_usage_used{domain="java.lang",type="Memory"} 512
+# TYPE jolokia_thread_count gauge
+jolokia_thread_count{domain="java.lang",type="Threading"} 42
+`
+	if got != want {
+		t.Errorf("unexpected metrics:\n%s\nwant:\n%s", got, want)
+	}
+	//the payload MUST be stable, otherwise the push deduplication never skips.
+	for i := 0; i < 10; i++ {
+		if again := collectForTest(t, e, target); again != got {
+			t.Fatalf("payload changed between scrapes:\n%s", again)
+		}
+	}
+}
+
+func TestCollectJolokiaAllFailed(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"status": 404, "error": "not found"}]`))
+	})
+	e, target := newTestTarget(t, handler, "jolokia", "mbeans:\n- mbean: java.lang:type=Missing\n")
+	if _, err := fetchMetrics(&scrapeClient{}, e, target); err == nil || !strings.Contains(err.Error(), "failed to read all") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseObjectName(t *testing.T) {
+	tests := []struct {
+		name       string
+		domain     string
+		properties map[string]string
+		valid      bool
+	}{
+		{"java.lang:type=Memory", "java.lang", map[string]string{"type": "Memory"}, true},
+		{`kafka:type=x,name="a,b"`, "kafka", map[string]string{"type": "x", "name": "a,b"}, true},
+		{"java.lang:type=GarbageCollector,*", "java.lang", map[string]string{"type": "GarbageCollector"}, true},
+		{"java.lang", "", nil, false},
+		{"java.lang:=x", "", nil, false},
+	}
+	for _, test := range tests {
+		domain, properties, err := parseObjectName(test.name)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if domain != test.domain || len(properties) != len(test.properties) {
+			t.Errorf("%s: got %s %v", test.name, domain, properties)
+		}
+		for k, v := range test.properties {
+			if properties[k] != v {
+				t.Errorf("%s: got %s=%s, want %s", test.name, k, properties[k], v)
+			}
+		}
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{"HeapMemoryUsage": "heap_memory_usage", "used": "used", "HTTPRequests": "http_requests", "CPUTime": "cpu_time"} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
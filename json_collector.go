@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonMapping converts the fields of a JSON document into metrics, e.g.
+//
+//	metrics:
+//	- name: app_queue_size
+//	  type: gauge
+//	  path: $.queues[*].size
+//	  labels:
+//	    queue: $1.name
+type jsonMapping struct {
+	Metrics []*jsonMetricRule `yaml:"metrics"`
+}
+
+// jsonMetricRule maps every value matched by the path to a series, the label values can be
+// literals, "$N" (the key or index matched by the Nth wildcard) or "$N.path" (a field of the element matched by the Nth wildcard).
+type jsonMetricRule struct {
+	Name       string            `yaml:"name"`
+	Type       string            `yaml:"type"`
+	Help       string            `yaml:"help"`
+	Path       string            `yaml:"path"`
+	Labels     map[string]string `yaml:"labels"`
+	metricType dto.MetricType
+	selector   jsonSelector
+	labels     map[string]*jsonLabelRef
+}
+
+type jsonLabelRef struct {
+	Literal  string
+	Capture  int //1-based index of the wildcard, 0 for the literal.
+	Selector jsonSelector
+}
+
+// jsonSelector is a compiled JSONPath-like selector, e.g. "$.a.b[0].c", "$.items[*].size", "$.workers.*.busy".
+type jsonSelector []jsonSegment
+
+type jsonSegment struct {
+	Field    string
+	Index    int
+	IsIndex  bool
+	Wildcard bool
+}
+
+// jsonMatch is a value matched by a selector together with the keys & elements matched by its wildcards.
+type jsonMatch struct {
+	Value    interface{}
+	Captures []jsonCapture
+}
+
+type jsonCapture struct {
+	Key   string
+	Value interface{}
+}
+
+func validateJSONMapping(config []byte) error {
+	_, err := parseJSONMapping(config)
+	return err
+}
+
+func parseJSONMapping(config []byte) (*jsonMapping, error) {
+	mapping := &jsonMapping{}
+	if err := yaml.UnmarshalStrict(config, mapping); err != nil {
+		return nil, err
+	}
+	if len(mapping.Metrics) == 0 {
+		return nil, fmt.Errorf("no metric is defined")
+	}
+	var err error
+	for i, rule := range mapping.Metrics {
+		if !model.IsValidMetricName(model.LabelValue(rule.Name)) {
+			return nil, fmt.Errorf("metrics[%d]: invalid metric name \"%s\"", i, rule.Name)
+		}
+		if rule.metricType, err = parseMetricType(rule.Type); err != nil {
+			return nil, fmt.Errorf("metrics[%d]: %s", i, err.Error())
+		}
+		if rule.selector, err = parseJSONSelector(rule.Path); err != nil {
+			return nil, fmt.Errorf("metrics[%d]: invalid path \"%s\": %s", i, rule.Path, err.Error())
+		}
+		rule.labels = make(map[string]*jsonLabelRef, len(rule.Labels))
+		for name, value := range rule.Labels {
+			if !model.LabelName(name).IsValid() {
+				return nil, fmt.Errorf("metrics[%d]: invalid label name \"%s\"", i, name)
+			}
+			ref, err := parseJSONLabelRef(value, rule.selector.wildcards())
+			if err != nil {
+				return nil, fmt.Errorf("metrics[%d]: invalid label \"%s\": %s", i, name, err.Error())
+			}
+			rule.labels[name] = ref
+		}
+	}
+	return mapping, nil
+}
+
+func parseJSONLabelRef(value string, wildcards int) (*jsonLabelRef, error) {
+	if !strings.HasPrefix(value, "$") || len(value) < 2 || value[1] < '0' || value[1] > '9' {
+		return &jsonLabelRef{Literal: value}, nil
+	}
+	end := 1
+	for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+		end++
+	}
+	capture, _ := strconv.Atoi(value[1:end])
+	if capture < 1 || capture > wildcards {
+		return nil, fmt.Errorf("the path has ONLY %d wildcard(s)", wildcards)
+	}
+	selector, err := parseJSONSelector("$" + value[end:])
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLabelRef{Capture: capture, Selector: selector}, nil
+}
+
+func parseJSONSelector(path string) (jsonSelector, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path must start with \"$\"")
+	}
+	selector := jsonSelector{}
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, "*") {
+				selector = append(selector, jsonSegment{Wildcard: true})
+				rest = rest[1:]
+				continue
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty field name")
+			}
+			selector = append(selector, jsonSegment{Field: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed \"[\"")
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				selector = append(selector, jsonSegment{Wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				selector = append(selector, jsonSegment{Field: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid index \"%s\"", inner)
+				}
+				selector = append(selector, jsonSegment{Index: index, IsIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected character \"%c\"", rest[0])
+		}
+	}
+	return selector, nil
+}
+
+func (s jsonSelector) wildcards() int {
+	count := 0
+	for _, segment := range s {
+		if segment.Wildcard {
+			count++
+		}
+	}
+	return count
+}
+
+// eval returns all values matched by the selector, the keys of objects are visited in order.
+func (s jsonSelector) eval(doc interface{}) []jsonMatch {
+	matches := []jsonMatch{}
+	s.walk(doc, nil, &matches)
+	return matches
+}
+
+func (s jsonSelector) walk(value interface{}, captures []jsonCapture, matches *[]jsonMatch) {
+	if len(s) == 0 {
+		*matches = append(*matches, jsonMatch{Value: value, Captures: captures})
+		return
+	}
+	segment, rest := s[0], s[1:]
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if segment.Wildcard {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				rest.walk(v[k], appendCapture(captures, k, v[k]), matches)
+			}
+		} else if !segment.IsIndex {
+			if child, ok := v[segment.Field]; ok {
+				rest.walk(child, captures, matches)
+			}
+		}
+	case []interface{}:
+		if segment.Wildcard {
+			for i, child := range v {
+				rest.walk(child, appendCapture(captures, strconv.Itoa(i), child), matches)
+			}
+		} else if segment.IsIndex && segment.Index < len(v) {
+			rest.walk(v[segment.Index], captures, matches)
+		}
+	}
+}
+
+func appendCapture(captures []jsonCapture, key string, value interface{}) []jsonCapture {
+	copied := make([]jsonCapture, len(captures), len(captures)+1)
+	copy(copied, captures)
+	return append(copied, jsonCapture{Key: key, Value: value})
+}
+
+// jsonNumber converts the numbers, booleans and numeric strings into float values.
+func jsonNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func jsonString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+func (r *jsonLabelRef) resolve(captures []jsonCapture) (string, bool) {
+	if r.Capture == 0 {
+		return r.Literal, true
+	}
+	capture := captures[r.Capture-1]
+	if len(r.Selector) == 0 {
+		return capture.Key, true
+	}
+	matches := r.Selector.eval(capture.Value)
+	if len(matches) == 0 {
+		return "", false
+	}
+	return jsonString(matches[0].Value)
+}
+
+// convert applies the mapping to the document, the values which are not numeric are skipped.
+func (mapping *jsonMapping) convert(doc interface{}, limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	builder := newFamilyBuilder()
+	for _, rule := range mapping.Metrics {
+	matches:
+		for _, match := range rule.selector.eval(doc) {
+			value, ok := jsonNumber(match.Value)
+			if !ok {
+				continue
+			}
+			labels := make(map[string]string, len(rule.labels))
+			for name, ref := range rule.labels {
+				if labels[name], ok = ref.resolve(match.Captures); !ok {
+					continue matches
+				}
+			}
+			builder.add(rule.Name, rule.Help, rule.metricType, value, labels)
+		}
+	}
+	return builder.build(limits)
+}
+
+func collectJSON(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error) {
+	config, err := loadTypeConfig(e)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := parseJSONMapping(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON mapping: %s", err.Error())
+	}
+	body, err := getBody(c, e, t, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err = json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %s", err.Error())
+	}
+	return mapping.convert(doc, e.Limits)
+}
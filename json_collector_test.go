@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJSONConvert(t *testing.T) {
+	doc := `{
+	"uptime": 3600,
+	"ready": true,
+	"version": "1.2.3",
+	"ratio": "0.5",
+	"queues": [{"name": "orders", "size": 3}, {"name": "mails", "size": "7"}, {"size": 1}],
+	"workers": {"w2": {"busy": false, "host": "b"}, "w1": {"busy": true, "host": "a"}},
+	"shards": {"s1": {"tables": {"users": {"rows": 10}, "jobs": {"rows": 2}}}},
+	"odd": {"a.b": 1, "list": [10, 20]}
+}`
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name: "fields",
+			config: `metrics:
+- name: app_uptime_seconds
+  help: Uptime.
+  path: $.uptime
+- name: app_ready
+  path: $.ready
+- name: app_ratio
+  path: $.ratio
+- name: app_odd
+  path: $.odd['a.b']
+- name: app_second
+  path: $.odd.list[1]
+- name: app_out_of_range
+  path: $.odd.list[5]
+`,
+			want: `# TYPE app_odd gauge
+app_odd 1
+# TYPE app_ratio gauge
+app_ratio 0.5
+# TYPE app_ready gauge
+app_ready 1
+# TYPE app_second gauge
+app_second 20
+# HELP app_uptime_seconds Uptime.
+# TYPE app_uptime_seconds gauge
+app_uptime_seconds 3600
+`,
+		},
+		{
+			name: "non-numeric leaves",
+			config: `metrics:
+- name: app_version
+  path: $.version
+- name: app_queues
+  path: $.queues
+- name: app_missing
+  path: $.missing.field
+`,
+			want: ``,
+		},
+		{
+			name: "array wildcard with captures",
+			config: `metrics:
+- name: app_queue_size
+  type: gauge
+  path: $.queues[*].size
+  labels:
+    queue: $1.name
+    index: $1
+    env: prod
+`,
+			want: `# TYPE app_queue_size gauge
+app_queue_size{env="prod",index="0",queue="orders"} 3
+app_queue_size{env="prod",index="1",queue="mails"} 7
+`,
+		},
+		{
+			name: "object wildcards",
+			config: `metrics:
+- name: app_worker_busy
+  path: $.workers.*.busy
+  labels:
+    worker: $1
+    host: $1.host
+- name: app_table_rows_total
+  type: counter
+  path: $.shards.*.tables[*].rows
+  labels:
+    shard: $1
+    table: $2
+`,
+			want: `# TYPE app_table_rows_total counter
+app_table_rows_total{shard="s1",table="jobs"} 2
+app_table_rows_total{shard="s1",table="users"} 10
+# TYPE app_worker_busy gauge
+app_worker_busy{host="a",worker="w1"} 1
+app_worker_busy{host="b",worker="w2"} 0
+`,
+		},
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(doc), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		mapping, err := parseJSONMapping([]byte(test.config))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		families, err := mapping.convert(parsed, scrapeLimits{})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		if got := encodeForTest(t, families); got != test.want {
+			t.Errorf("%s: unexpected metrics:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseJSONMapping(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{config: "metrics: []\n", err: "no metric is defined"},
+		{config: "metrics:\n- name: 1abc\n  path: $.a\n", err: "invalid metric name"},
+		{config: "metrics:\n- name: a\n  type: histogram\n  path: $.a\n", err: "metrics[0]"},
+		{config: "metrics:\n- name: a\n  path: a.b\n", err: "must start with"},
+		{config: "metrics:\n- name: a\n  path: $..b\n", err: "empty field name"},
+		{config: "metrics:\n- name: a\n  path: $.a[0\n", err: "unclosed"},
+		{config: "metrics:\n- name: a\n  path: $.a[-1]\n", err: "invalid index"},
+		{config: "metrics:\n- name: a\n  path: $.a[x]\n", err: "invalid index"},
+		{config: "metrics:\n- name: a\n  path: $a\n", err: "unexpected character"},
+		{config: "metrics:\n- name: a\n  path: $.a[*]\n  labels:\n    b: $2\n", err: "ONLY 1 wildcard"},
+		{config: "metrics:\n- name: a\n  path: $.a\n  labels:\n    b: $1\n", err: "ONLY 0 wildcard"},
+		{config: "metrics:\n- name: a\n  path: $.a[*]\n  labels:\n    b-c: x\n", err: "invalid label name"},
+		{config: "metrics:\n- name: a\n  path: $.a\n  unknown: 1\n", err: "unknown"},
+	}
+	for _, test := range tests {
+		if _, err := parseJSONMapping([]byte(test.config)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: unexpected error: %v, want: %s", test.config, err, test.err)
+		}
+	}
+}
+
+func TestCollectJSON(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/json" {
+			t.Errorf("unexpected Accept header: %s", r.Header.Get("Accept"))
+		}
+		w.Write([]byte(`{"queues": [{"name": "orders", "size": 3}]}`))
+	})
+	e, target := newTestTarget(t, handler, "json", "metrics:\n- name: app_queue_size\n  path: $.queues[*].size\n  labels:\n    queue: $1.name\n")
+	target.Path = "/status"
+	if got := collectForTest(t, e, target); got != "# TYPE app_queue_size gauge\napp_queue_size{queue=\"orders\"} 3\n" {
+		t.Errorf("unexpected metrics:\n%s", got)
+	}
+	e, target = newTestTarget(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"queues": `))
+	}), "json", "metrics:\n- name: a\n  path: $.a\n")
+	if _, err := fetchMetrics(&scrapeClient{timeout: e.Timeout}, e, target); err == nil || !strings.Contains(err.Error(), "invalid JSON document") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
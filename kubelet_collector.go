@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	kubeletSource                  = "kubelet"
+	defaultKubeletPort             = "10250"
+	defaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+var (
+	//the metrics of cAdvisor take precedence over the resource metrics with the same names.
+	kubeletMetricsPaths        = []string{"/metrics/cadvisor", "/metrics/resource"}
+	nodeCollector              *kubeletCollector
+	kubeletFetchSucceedCounter = prometheus.NewCounter(prometheus.CounterOpts{Name: "fetch_kubelet_metrics_succeed_count_total", Help: "Total count of successful fetch the local kubelet metric endpoints."})
+	kubeletFetchFailedCounter  = prometheus.NewCounter(prometheus.CounterOpts{Name: "fetch_kubelet_metrics_failed_count_total", Help: "Total count of failed fetching the local kubelet metric endpoints."})
+)
+
+// kubeletCollector scrapes the container metrics of the annotated PODs from the local kubelet,
+// and pushes them under the same grouping key as the PODs' own metrics.
+type kubeletCollector struct {
+	URL       string
+	client    *http.Client
+	tokenFile *reloadableFile
+	interval  time.Duration
+}
+
+func initializeKubeletCollector() {
+	if !args.EnableKubeletCollector {
+		return
+	}
+	log.Infoln("Initializing kubelet collector...")
+	prometheus.MustRegister(kubeletFetchSucceedCounter)
+	prometheus.MustRegister(kubeletFetchFailedCounter)
+	c, err := newKubeletCollector()
+	if err != nil {
+		log.Panicf("Failed to initialize kubelet collector, error: %s", err.Error())
+	}
+	nodeCollector = c
+	go c.run()
+}
+
+func newKubeletCollector() (*kubeletCollector, error) {
+	interval, err := parsePositiveDuration(args.FechingInterval)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parsePositiveDuration(args.FechingTimeout)
+	if err != nil {
+		return nil, err
+	}
+	address := args.KubeletAddress
+	if address == "" {
+		address = "https://" + net.JoinHostPort(args.Host, defaultKubeletPort)
+	}
+	c := &kubeletCollector{URL: strings.TrimSuffix(address, "/"), interval: interval}
+	if args.KubeletTokenFile != "" {
+		c.tokenFile = &reloadableFile{Path: args.KubeletTokenFile}
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: args.KubeletInsecure}
+	if args.KubeletCAFile != "" {
+		data, err := ioutil.ReadFile(args.KubeletCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read CA file of kubelet, error: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No valid CA certificate found in file: %s", args.KubeletCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	c.client = &http.Client{Timeout: timeout, Transport: &http.Transport{MaxIdleConns: 2, TLSClientConfig: tlsConfig}}
+	return c, nil
+}
+
+func (c *kubeletCollector) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.collect()
+	}
+}
+
+func (c *kubeletCollector) collect() {
+	pods := monitoredPods()
+	if len(pods) == 0 {
+		return
+	}
+	grouped := map[string]map[string]*dto.MetricFamily{}
+	names := map[string]bool{}
+	for _, path := range kubeletMetricsPaths {
+		families, err := c.fetch(path)
+		if err != nil {
+			kubeletFetchFailedCounter.Inc()
+			log.Errorf("[Fetching Metric] Failed to fetch kubelet metrics: %s, error: %s", path, err.Error())
+			continue
+		}
+		kubeletFetchSucceedCounter.Inc()
+		current := map[string]bool{}
+		for _, mf := range families {
+			if names[mf.GetName()] {
+				continue
+			}
+			current[mf.GetName()] = true
+			groupByPod(mf, pods, grouped)
+		}
+		for name := range current {
+			names[name] = true
+		}
+	}
+	for key, byName := range grouped {
+		e := pods[key]
+		families := make([]*dto.MetricFamily, 0, len(byName))
+		for _, mf := range byName {
+			families = append(families, mf)
+		}
+		sortMetricFamilies(families)
+		data, err := buildPrometheusData(&e, scrapeTarget{}, families, false)
+		if err != nil {
+			log.Errorf("Failed to encode kubelet metrics, POD: %s, error: %s", e.Pod.Name, err.Error())
+			continue
+		}
+		data.Source = kubeletSource
+		prometheusOutputChan <- data
+	}
+}
+
+func (c *kubeletCollector) fetch(path string) ([]*dto.MetricFamily, error) {
+	req, err := http.NewRequest("GET", c.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.tokenFile != nil {
+		token, err := c.tokenFile.Get()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	req.Header.Set("Accept", scrapeAcceptHeader)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP response status code: %d", rsp.StatusCode)
+	}
+	return decodeResponse(rsp, scrapeLimits{})
+}
+
+// groupByPod splits the series of the family by the "namespace" & "pod" labels, the series of other PODs are dropped.
+func groupByPod(mf *dto.MetricFamily, pods map[string]PODEvent, grouped map[string]map[string]*dto.MetricFamily) {
+	for _, m := range mf.Metric {
+		namespace, pod := "", ""
+		for _, l := range m.Label {
+			switch l.GetName() {
+			case "namespace":
+				namespace = l.GetValue()
+			case "pod", "pod_name": //"pod_name" is used before Kubernetes v1.16.
+				if l.GetValue() != "" {
+					pod = l.GetValue()
+				}
+			}
+		}
+		key := namespace + "/" + pod
+		if _, ok := pods[key]; !ok {
+			continue
+		}
+		byName, ok := grouped[key]
+		if !ok {
+			byName = map[string]*dto.MetricFamily{}
+			grouped[key] = byName
+		}
+		family, ok := byName[mf.GetName()]
+		if !ok {
+			family = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+			byName[mf.GetName()] = family
+		}
+		//the push gateway rejects the samples with timestamps.
+		m.TimestampMs = nil
+		family.Metric = append(family.Metric, m)
+	}
+}
+
+// monitoredPods returns the latest events of the PODs being monitored, keyed by "namespace/name".
+func monitoredPods() map[string]PODEvent {
+	lock.Lock()
+	defer lock.Unlock()
+	pods := make(map[string]PODEvent, len(monitoringPods))
+	for _, m := range monitoringPods {
+		if m.finalizing {
+			continue
+		}
+		e := m.snapshot()
+		pods[e.Pod.Namespace+"/"+e.Pod.Name] = e
+	}
+	return pods
+}
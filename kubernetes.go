@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -11,7 +12,9 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -21,8 +24,9 @@ const (
 )
 
 var (
-	k8sClient *kubernetes.Clientset
-	eventChan chan *PODEvent
+	k8sClient  *kubernetes.Clientset
+	eventChan  chan *PODEvent
+	podIndexer cache.Indexer //cache of the PODs on current node.
 )
 
 type PODStatus int
@@ -31,10 +35,25 @@ type PODEvent struct {
 	Status                    PODStatus
 	MetricType                string
 	Endpoints                 string
+	Targets                   []scrapeTarget //resolved endpoints.
+	Config                    string         //inline type specific configuration.
+	ConfigMap                 string         //ConfigMap which holds the type specific configuration.
 	FechingInterval           string
 	FechingTimeout            string
 	LabeledNamespace          string
+	Scheme                    string
+	TLSInsecure               bool
+	ServerName                string
+	AuthSecret                string
+	Tenant                    string
+	Limits                    scrapeLimits
+	Interval                  time.Duration
+	Timeout                   time.Duration
+	HostNetworkAddress        string        //"host" or "localhost", ONLY used for the hostNetwork PODs.
+	WarmUp                    time.Duration //delay after the POD became ready before fetching.
+	DeleteDelay               time.Duration //delay before removing the pushed metrics of a terminated POD.
 	HasAnnotation             bool
+	Errors                    []string //validation errors of the annotations.
 	NeededAppendingAnnotation string
 }
 
@@ -46,52 +65,130 @@ func (e *PODEvent) UpdateAnnotation() {
 	}
 }
 
+// ParseAnnotation parses and strictly validates POD's annotations, a POD with any invalid annotation will be rejected.
 func (e *PODEvent) ParseAnnotation() {
-	if e.Pod.Annotations != nil && len(e.Pod.Annotations) > 0 {
-		//e.g. io.collectbeat.metrics/type
-		if metricType, ok := e.Pod.Annotations[args.AnnotationPrefixTag+"/type"]; ok {
-			//ONLY "prometheus" can be supported.
-			if strings.ToLower(metricType) != "prometheus" {
-				log.Warnf("Skipped POD: %s which has been set to an unsupported type of metric type(%s)", e.Pod.Name, metricType)
-				return
-			}
-			//e.g. io.collectbeat.metrics/endpoints
-			if eps, ok := e.Pod.Annotations[args.AnnotationPrefixTag+"/endpoints"]; ok {
-				e.MetricType = metricType
-				e.Endpoints = eps
-				e.HasAnnotation = true
-				//try to detect fetching interval.
-				if interval, ok := e.Pod.Annotations[args.AnnotationPrefixTag+"/interval"]; ok {
-					e.FechingInterval = interval
-				} else {
-					e.FechingInterval = args.FechingInterval
-				}
-				//try to detect fetching timeout.
-				if timeout, ok := e.Pod.Annotations[args.AnnotationPrefixTag+"/timeout"]; ok {
-					e.FechingTimeout = timeout
-				} else {
-					e.FechingTimeout = args.FechingTimeout
-				}
-				//try to detect labeled namespace.
-				if ns, ok := e.Pod.Annotations[args.AnnotationPrefixTag+"/namespace"]; ok {
-					e.LabeledNamespace = ns
-				} else {
-					e.LabeledNamespace = args.LabeledNamespace
-				}
-			}
+	e.HasAnnotation = false
+	e.Errors = nil
+	//e.g. io.collectbeat.metrics/type
+	metricType, ok := e.annotation("type")
+	if !ok {
+		return
+	}
+	e.HasAnnotation = true
+	e.MetricType = strings.ToLower(metricType)
+	if !isSupportedMetricType(e.MetricType) {
+		e.addError("unsupported metrics type \"%s\"", metricType)
+	}
+	//e.g. io.collectbeat.metrics/endpoints, io.collectbeat.metrics/<container>.endpoints
+	e.resolveTargets()
+	//e.g. io.collectbeat.metrics/config, io.collectbeat.metrics/config-map
+	e.parseTypeConfig()
+	//try to detect fetching interval.
+	e.FechingInterval = e.annotationOrDefault("interval", args.FechingInterval)
+	interval, err := parsePositiveDuration(e.FechingInterval)
+	if err != nil {
+		e.addError("invalid interval \"%s\": %s", e.FechingInterval, err.Error())
+	}
+	e.Interval = interval
+	//try to detect fetching timeout.
+	e.FechingTimeout = e.annotationOrDefault("timeout", args.FechingTimeout)
+	timeout, err := parsePositiveDuration(e.FechingTimeout)
+	if err != nil {
+		e.addError("invalid timeout \"%s\": %s", e.FechingTimeout, err.Error())
+	} else if interval > 0 && timeout > interval {
+		e.addError("timeout \"%s\" CANNOT be greater than interval \"%s\"", e.FechingTimeout, e.FechingInterval)
+	}
+	e.Timeout = timeout
+	warmUp := e.annotationOrDefault("warm-up", args.WarmUp)
+	if e.WarmUp, err = parseNonNegativeDuration(warmUp); err != nil {
+		e.addError("invalid warm-up \"%s\": %s", warmUp, err.Error())
+	}
+	deleteDelay := e.annotationOrDefault("delete-delay", args.DeleteDelay)
+	if e.DeleteDelay, err = parseNonNegativeDuration(deleteDelay); err != nil {
+		e.addError("invalid delete-delay \"%s\": %s", deleteDelay, err.Error())
+	}
+	//try to detect labeled namespace.
+	e.LabeledNamespace = e.annotationOrDefault("namespace", args.LabeledNamespace)
+	//try to detect scraping scheme, "http" by default.
+	e.Scheme = strings.ToLower(e.annotationOrDefault("scheme", "http"))
+	if e.Scheme != "http" && e.Scheme != "https" {
+		e.addError("unsupported scheme \"%s\"", e.Scheme)
+	}
+	if insecure, ok := e.annotation("tls-insecure"); ok {
+		if e.TLSInsecure, err = strconv.ParseBool(insecure); err != nil {
+			e.addError("invalid tls-insecure \"%s\", a boolean is required", insecure)
 		}
-	} else {
-		e.HasAnnotation = false
+	}
+	e.ServerName, _ = e.annotation("server-name")
+	e.HostNetworkAddress = strings.ToLower(e.annotationOrDefault("host-network-address", args.HostNetworkAddress))
+	if !isSupportedHostNetworkAddress(e.HostNetworkAddress) {
+		e.addError("unsupported host-network-address \"%s\"", e.HostNetworkAddress)
+	}
+	//name of the Secret (in POD's namespace) which holds the scraping credentials.
+	e.AuthSecret, _ = e.annotation("auth-secret")
+	//name of the destination which the metrics should be routed to.
+	e.Tenant, _ = e.annotation("tenant")
+	e.parseLimits()
+	if len(e.Errors) > 0 {
+		log.Warnf("Rejected POD: %s which has invalid annotations: %s", e.Pod.Name, strings.Join(e.Errors, "; "))
+		recorder.Warningf(podReference(e.Pod), eventReasonInvalidAnnotation, "Invalid metrics annotations: %s", strings.Join(e.Errors, "; "))
 	}
 }
 
-func initializeK8SInformer() chan *PODEvent {
-	log.Infoln("Initializing Kubernetes informer...")
+// monitorable returns true if the POD has been annotated without any error.
+func (e *PODEvent) monitorable() bool {
+	return e.HasAnnotation && len(e.Errors) == 0
+}
+
+func (e *PODEvent) annotation(name string) (string, bool) {
+	v, ok := e.Pod.Annotations[args.AnnotationPrefixTag+"/"+name]
+	return strings.TrimSpace(v), ok
+}
+
+func (e *PODEvent) annotationOrDefault(name, defaultValue string) string {
+	if v, ok := e.annotation(name); ok {
+		return v
+	}
+	return defaultValue
+}
+
+func (e *PODEvent) addError(format string, a ...interface{}) {
+	e.Errors = append(e.Errors, fmt.Sprintf(format, a...))
+}
+
+// parseLimits overrides the global scraping limits by POD's annotations.
+func (e *PODEvent) parseLimits() {
+	e.Limits = args.ScrapeLimits
+	var err error
+	if v, ok := e.annotation("body-size-limit"); ok {
+		if e.Limits.BodySizeLimit, err = parseBodySizeLimit(v); err != nil {
+			e.addError("%s", err.Error())
+		}
+	}
+	if v, ok := e.annotation("sample-limit"); ok {
+		if e.Limits.SampleLimit, err = parseCountLimit(v); err != nil {
+			e.addError("invalid sample-limit: %s", err.Error())
+		}
+	}
+	if v, ok := e.annotation("label-limit"); ok {
+		if e.Limits.LabelLimit, err = parseCountLimit(v); err != nil {
+			e.addError("invalid label-limit: %s", err.Error())
+		}
+	}
+}
+
+func initializeK8SClient() error {
 	var err error
 	k8sClient, err = kubernetes.NewForConfig(&rest.Config{Host: args.KubernetesAddress, BearerToken: args.KubernetesBearerToken})
-	if err != nil {
+	return err
+}
+
+func initializeK8SInformer() chan *PODEvent {
+	log.Infoln("Initializing Kubernetes informer...")
+	if err := initializeK8SClient(); err != nil {
 		log.Panicf("CANNOT init Kubernetes client, error: %s", err.Error())
 	}
+	initializeEventRecorder()
 	sharedFactory := informers.NewSharedInformerFactory(k8sClient, 0)
 	sharedFactory.WaitForCacheSync(make(chan struct{}))
 	log.Infoln("Fully synchronizing PODs...")
@@ -114,8 +211,9 @@ func syncPods() {
 		},
 		&corev1.Pod{},
 		0, //Skip resyncr
-		cache.Indexers{},
+		cache.Indexers{podIPIndex: indexPodByIP, podUIDIndex: indexPodByUID},
 	)
+	podIndexer = informer.GetIndexer()
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			log.Debugf("informer ADD event received: %s", obj.(*corev1.Pod).Name)
@@ -137,6 +235,23 @@ func syncPods() {
 	go informer.Run(make(chan struct{}) /*ignored stop signal.*/)
 }
 
+// livePod returns the latest state of the POD from the informer cache,
+// or the given one if it is not cached (e.g. has been deleted).
+func livePod(pod *corev1.Pod) *corev1.Pod {
+	if podIndexer == nil {
+		return pod
+	}
+	obj, exists, err := podIndexer.GetByKey(pod.Namespace + "/" + pod.Name)
+	if err != nil || !exists {
+		return pod
+	}
+	cached, ok := obj.(*corev1.Pod)
+	if !ok || cached.UID != pod.UID {
+		return pod
+	}
+	return cached
+}
+
 func handlePodModify(pod *corev1.Pod, status PODStatus) {
 	pe := &PODEvent{Status: status, Pod: pod}
 	//try parsing annotation.
@@ -147,6 +262,10 @@ func handlePodModify(pod *corev1.Pod, status PODStatus) {
 }
 
 func updatePod(e *PODEvent) {
+	if dryRun != nil {
+		dryRun.recordAnnotation(e)
+		return
+	}
 	newPod, err := k8sClient.CoreV1().Pods(e.Pod.Namespace).Get(e.Pod.Name, meta_v1.GetOptions{})
 	if err != nil {
 		log.Errorf("Cannot update POD: %s to newest status, error: %s", e.Pod.Name, err.Error())
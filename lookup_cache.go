@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// lookupCache keeps the results (errors included) of the on-demand lookups of the Kubernetes objects for a while,
+// so that the bridge never watches such objects of the whole cluster, nor asks the API server on every scrape.
+type lookupCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]*lookupEntry
+	loading map[string]*lookupCall //the lookups in flight, shared by the concurrent callers of the same key.
+}
+
+type lookupEntry struct {
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+type lookupCall struct {
+	done  chan struct{} //closed once the value & err have been set.
+	value interface{}
+	err   error
+}
+
+func newLookupCache(ttl time.Duration) *lookupCache {
+	return &lookupCache{ttl: ttl, entries: map[string]*lookupEntry{}, loading: map[string]*lookupCall{}}
+}
+
+// get returns the cached result of the key, or the result of load if it has expired.
+// The concurrent callers of the same key wait for a single load instead of asking the API server together.
+func (c *lookupCache) get(key string, load func() (interface{}, error)) (interface{}, error) {
+	now := time.Now()
+	c.lock.Lock()
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expires) {
+		c.lock.Unlock()
+		return entry.value, entry.err
+	}
+	if call, ok := c.loading[key]; ok {
+		c.lock.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &lookupCall{done: make(chan struct{})}
+	c.loading[key] = call
+	c.lock.Unlock()
+	call.value, call.err = load()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	//the expired entries are purged on every miss, since only a few objects are referenced by the PODs on a node.
+	for k, v := range c.entries {
+		if !now.Before(v.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = &lookupEntry{value: call.value, err: call.err, expires: now.Add(c.ttl)}
+	delete(c.loading, key)
+	close(call.done)
+	return call.value, call.err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLookupCacheCoalesces(t *testing.T) {
+	c := newLookupCache(time.Minute)
+	var loads int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	load := func() (interface{}, error) {
+		if atomic.AddInt32(&loads, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return "value", nil
+	}
+	var wg sync.WaitGroup
+	results := make([]interface{}, 10)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = c.get("ns/secret", load)
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = c.get("ns/secret", load)
+		}(i)
+	}
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("unexpected count of loads: %d", loads)
+	}
+	for i, result := range results {
+		if result != "value" {
+			t.Errorf("unexpected result of caller %d: %v", i, result)
+		}
+	}
+}
+
+func TestLookupCacheExpires(t *testing.T) {
+	c := newLookupCache(20 * time.Millisecond)
+	loads := 0
+	load := func() (interface{}, error) {
+		loads++
+		return nil, errors.New("not found")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.get("ns/secret", load); err == nil || err.Error() != "not found" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("the error is NOT cached, count of loads: %d", loads)
+	}
+	time.Sleep(30 * time.Millisecond)
+	c.get("ns/secret", load)
+	if loads != 2 || len(c.entries) != 1 || len(c.loading) != 0 {
+		t.Errorf("unexpected state after expiration, loads: %d, entries: %d, loading: %d", loads, len(c.entries), len(c.loading))
+	}
+}
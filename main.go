@@ -8,10 +8,18 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheckCommand(os.Args[2:]))
+	}
 	args = initializeArg()
+	initializeDryRun()
 	ch := initializeK8SInformer()
 	resultChan := initKubernetesPODEventProcessor(ch)
+	initializeKubeletCollector()
+	initializeStatsDReceiver()
+	initializeOTLPReceiver()
 	initializePrometheusPusher(resultChan)
+	initializePushgatewayRelay() //relies on the push router.
 	fmt.Println("Crystal Bridge has been started successfully!")
 	select {} //block current process.
 }
@@ -21,18 +29,7 @@ var (
 )
 
 func initializeArg() *CommandLineArgs {
-	arg := CommandLineArgs{}
-	flag.IntVar(&arg.LogLevel, "l", 2, "log level.")
-	flag.StringVar(&arg.RemotePrometheusPushGWAddr, "gw", "", "the accessabile address of remote prometheus push gateway.")
-	flag.StringVar(&arg.RemotePrometheusPushGWAddrHttpTimeout, "gwto", "30s", "timeout to push data to the remote Prometheus GW.")
-	flag.StringVar(&arg.AnnotationPrefixTag, "tag", "io.collectbeat.metrics", "a prefix value used for matching POD's annotations.")
-	flag.IntVar(&arg.PrometheusDataSyncBufferSize, "syncbuffer", 32, "length of buffered queue size for syncing data to the remote Prometheus push gateway")
-	flag.StringVar(&arg.Host, "host", "", "hostname, usually be set as current machine's IP address.")
-	flag.StringVar(&arg.FechingInterval, "fi", "1m", "fetching interval")
-	flag.StringVar(&arg.FechingTimeout, "ft", "3s", "fetching timeout")
-	flag.StringVar(&arg.LabeledNamespace, "lns", "3s", "labeled namespace on the POD's annotation.")
-	flag.StringVar(&arg.KubernetesAddress, "k8saddr", "", "remote Kubernetes URL. e.g. http://xxx.xxx.xxx.xxx:8080")
-	flag.StringVar(&arg.KubernetesBearerToken, "k8sbt", "", "Kubernetes bearer token")
+	arg := defineArgs(flag.CommandLine)
 	flag.Parse()
 
 	fmt.Println("Initializing logger...")
@@ -44,19 +41,142 @@ func initializeArg() *CommandLineArgs {
 		}
 	}
 	fmt.Printf("Host: %s\n", arg.Host)
+	if err := finalizeArgs(arg); err != nil {
+		log.Fatal(err.Error())
+	}
 	//minimum level to log.
 	log.SetLevel(log.Level(arg.LogLevel))
+	return arg
+}
+
+// defineArgs registers all arguments to the given flag set, so that they can be shared with the sub-commands.
+func defineArgs(fs *flag.FlagSet) *CommandLineArgs {
+	arg := CommandLineArgs{RemotePrometheusPushGWHeaders: headerFlags{}}
+	fs.IntVar(&arg.LogLevel, "l", 2, "log level.")
+	fs.StringVar(&arg.RemotePrometheusPushGWAddr, "gw", "", "the accessabile address of remote prometheus push gateway. e.g. xxx.xxx.xxx.xxx:9091 or https://xxx.xxx.xxx.xxx:9091")
+	fs.StringVar(&arg.RemotePrometheusPushGWAddrHttpTimeout, "gwto", "30s", "timeout to push data to the remote Prometheus GW.")
+	fs.StringVar(&arg.RemotePrometheusPushGWCAFile, "gwca", "", "CA certificate file for verifying the remote Prometheus push gateway over HTTPS.")
+	fs.StringVar(&arg.RemotePrometheusPushGWCertFile, "gwcert", "", "client certificate file for pushing data to the remote Prometheus push gateway over mTLS.")
+	fs.StringVar(&arg.RemotePrometheusPushGWKeyFile, "gwkey", "", "client private key file for pushing data to the remote Prometheus push gateway over mTLS.")
+	fs.BoolVar(&arg.RemotePrometheusPushGWInsecure, "gwinsecure", false, "skip verifying the TLS certificate of the remote Prometheus push gateway.")
+	fs.StringVar(&arg.RemotePrometheusPushGWUsername, "gwuser", "", "username of basic auth for the remote Prometheus push gateway.")
+	fs.StringVar(&arg.RemotePrometheusPushGWPasswordFile, "gwpwdfile", "", "file which contains the password of basic auth for the remote Prometheus push gateway.")
+	fs.StringVar(&arg.RemotePrometheusPushGWTokenFile, "gwtokenfile", "", "file which contains the bearer token for the remote Prometheus push gateway.")
+	fs.Var(arg.RemotePrometheusPushGWHeaders, "gwheader", "extra HTTP header sent to the remote Prometheus push gateway, formatted as \"Name: Value\". can be repeated.")
+	fs.BoolVar(&arg.DryRun, "dry-run", false, "discover & scrape PODs normally, but ONLY record what would have been written to Kubernetes or pushed to the push gateways.")
+	fs.StringVar(&arg.RoutingFile, "routes", "", "YAML file which describes the tenant routing rules to different push gateways.")
+	fs.BoolVar(&arg.PushDeduplication, "dedup", false, "skip pushing the metrics which are byte-identical to the last pushed ones.")
+	fs.IntVar(&arg.PushDeduplicationMaxSkips, "dedupmaxskip", 10, "maximum count of continuously skipped pushes for a target before forcibly re-pushing it, 0 means unlimited.")
+	fs.StringVar(&arg.BodySizeLimit, "bodysizelimit", "0", "maximum size of a scrape's response body, e.g. 10Mi. 0 means unlimited.")
+	fs.IntVar(&arg.ScrapeLimits.SampleLimit, "samplelimit", 0, "maximum count of samples of a scrape. 0 means unlimited.")
+	fs.IntVar(&arg.ScrapeLimits.LabelLimit, "labellimit", 0, "maximum count of labels of every series. 0 means unlimited.")
+	fs.BoolVar(&arg.EnableEvents, "events", true, "record Kubernetes events against the PODs whose metrics cannot be bridged.")
+	fs.Float64Var(&arg.EventQPS, "eventqps", 0.5, "maximum average count of Kubernetes events recorded per second.")
+	fs.IntVar(&arg.EventBurst, "eventburst", 25, "maximum burst count of Kubernetes events recorded.")
+	fs.BoolVar(&arg.EnableKubeletCollector, "kubelet", false, "scrape the container metrics of the annotated PODs from the local kubelet (cAdvisor & resource metrics) and push them together.")
+	fs.StringVar(&arg.KubeletAddress, "kubeletaddr", "", "address of the local kubelet, \"https://<host>:10250\" by default.")
+	fs.StringVar(&arg.KubeletTokenFile, "kubelettokenfile", defaultServiceAccountTokenFile, "file which contains the bearer token for the kubelet.")
+	fs.StringVar(&arg.KubeletCAFile, "kubeletca", "", "CA certificate file for verifying the kubelet's serving certificate.")
+	fs.BoolVar(&arg.KubeletInsecure, "kubeletinsecure", false, "skip verifying the kubelet's serving certificate.")
+	fs.StringVar(&arg.StatsDAddress, "statsd", "", "UDP & TCP address to receive the StatsD samples from the PODs on current node, e.g. :8125. disabled if it is empty.")
+	fs.StringVar(&arg.StatsDMappingFile, "statsdmapping", "", "YAML file which describes the rules for mapping the StatsD names to Prometheus names & labels.")
+	fs.StringVar(&arg.StatsDFlushInterval, "statsdflush", "1m", "interval of pushing the aggregated StatsD metrics.")
+	fs.BoolVar(&arg.EnableOTLPReceiver, "otlp", false, "receive the OTLP/HTTP metrics (protobuf & JSON) exported by the PODs on current node at \"/v1/metrics\" of the bridge's port.")
+	fs.StringVar(&arg.OTLPFlushInterval, "otlpflush", "1m", "interval of pushing the received OTLP metrics.")
+	fs.BoolVar(&arg.EnablePushgatewayRelay, "relay", false, "relay the Pushgateway API requests (PUT/POST/DELETE \"/metrics/job/...\") sent by the PODs on current node to the bridge's port.")
+	fs.IntVar(&arg.RelayGroupLimit, "relaygroups", 100, "maximum count of the relayed grouping keys per namespace, 0 means unlimited.")
+	fs.Float64Var(&arg.RelayRateLimit, "relayrate", 0, "maximum count of the relayed requests per second per namespace, 0 means unlimited.")
+	fs.StringVar(&arg.AnnotationPrefixTag, "tag", "io.collectbeat.metrics", "a prefix value used for matching POD's annotations.")
+	fs.IntVar(&arg.PrometheusDataSyncBufferSize, "syncbuffer", 32, "length of buffered queue size for syncing data to the remote Prometheus push gateway")
+	fs.StringVar(&arg.Host, "host", "", "hostname, usually be set as current machine's IP address.")
+	fs.StringVar(&arg.FechingInterval, "fi", "1m", "fetching interval")
+	fs.StringVar(&arg.FechingTimeout, "ft", "3s", "fetching timeout")
+	fs.StringVar(&arg.HostNetworkAddress, "hostnetaddr", hostNetworkAddressHost, "address used for scraping the hostNetwork PODs, \"host\" (the node's IP) or \"localhost\" (127.0.0.1, requires running with hostNetwork).")
+	fs.StringVar(&arg.WarmUp, "warmup", "0s", "delay after the POD (or the container which owns the metrics port) became ready before fetching.")
+	fs.StringVar(&arg.DeleteDelay, "deletedelay", "0s", "delay before removing the pushed metrics of a terminating POD, so that its last fetched values can be collected.")
+	fs.StringVar(&arg.LabeledNamespace, "lns", "", "default namespace prefixed to the metric names, overridden by the POD's annotation.")
+	fs.StringVar(&arg.KubernetesAddress, "k8saddr", "", "remote Kubernetes URL. e.g. http://xxx.xxx.xxx.xxx:8080")
+	fs.StringVar(&arg.KubernetesBearerToken, "k8sbt", "", "Kubernetes bearer token")
 	return &arg
 }
 
+// finalizeArgs validates the arguments and parses the derived values.
+func finalizeArgs(arg *CommandLineArgs) error {
+	var err error
+	if _, err = parsePositiveDuration(arg.FechingInterval); err != nil {
+		return fmt.Errorf("Invalid fetching interval \"%s\", error: %s", arg.FechingInterval, err.Error())
+	}
+	if _, err = parsePositiveDuration(arg.FechingTimeout); err != nil {
+		return fmt.Errorf("Invalid fetching timeout \"%s\", error: %s", arg.FechingTimeout, err.Error())
+	}
+	if !isSupportedHostNetworkAddress(arg.HostNetworkAddress) {
+		return fmt.Errorf("Invalid host network address \"%s\"", arg.HostNetworkAddress)
+	}
+	if _, err = parseNonNegativeDuration(arg.WarmUp); err != nil {
+		return fmt.Errorf("Invalid warm-up delay \"%s\", error: %s", arg.WarmUp, err.Error())
+	}
+	if _, err = parseNonNegativeDuration(arg.DeleteDelay); err != nil {
+		return fmt.Errorf("Invalid delete delay \"%s\", error: %s", arg.DeleteDelay, err.Error())
+	}
+	if _, err = parsePositiveDuration(arg.StatsDFlushInterval); err != nil {
+		return fmt.Errorf("Invalid StatsD flush interval \"%s\", error: %s", arg.StatsDFlushInterval, err.Error())
+	}
+	if _, err = parsePositiveDuration(arg.OTLPFlushInterval); err != nil {
+		return fmt.Errorf("Invalid OTLP flush interval \"%s\", error: %s", arg.OTLPFlushInterval, err.Error())
+	}
+	if arg.RelayGroupLimit < 0 {
+		return fmt.Errorf("Invalid relay group limit %d", arg.RelayGroupLimit)
+	}
+	if arg.RelayRateLimit < 0 {
+		return fmt.Errorf("Invalid relay rate limit %g", arg.RelayRateLimit)
+	}
+	if arg.ScrapeLimits.BodySizeLimit, err = parseBodySizeLimit(arg.BodySizeLimit); err != nil {
+		return err
+	}
+	return nil
+}
+
 type CommandLineArgs struct {
 	LogLevel                              int
 	RemotePrometheusPushGWAddr            string
 	RemotePrometheusPushGWAddrHttpTimeout string
+	RemotePrometheusPushGWCAFile          string
+	RemotePrometheusPushGWCertFile        string
+	RemotePrometheusPushGWKeyFile         string
+	RemotePrometheusPushGWInsecure        bool
+	RemotePrometheusPushGWUsername        string
+	RemotePrometheusPushGWPasswordFile    string
+	RemotePrometheusPushGWTokenFile       string
+	RemotePrometheusPushGWHeaders         headerFlags
+	DryRun                                bool
+	RoutingFile                           string
+	PushDeduplication                     bool
+	PushDeduplicationMaxSkips             int
+	BodySizeLimit                         string
+	ScrapeLimits                          scrapeLimits
+	EnableEvents                          bool
+	EventQPS                              float64
+	EventBurst                            int
+	EnableKubeletCollector                bool
+	KubeletAddress                        string
+	KubeletTokenFile                      string
+	KubeletCAFile                         string
+	KubeletInsecure                       bool
+	StatsDAddress                         string
+	StatsDMappingFile                     string
+	StatsDFlushInterval                   string
+	EnableOTLPReceiver                    bool
+	OTLPFlushInterval                     string
+	EnablePushgatewayRelay                bool
+	RelayGroupLimit                       int
+	RelayRateLimit                        float64
 	Host                                  string //current machine's hostname (IP ADDRESS)
 	AnnotationPrefixTag                   string
 	FechingInterval                       string
 	FechingTimeout                        string
+	HostNetworkAddress                    string
+	WarmUp                                string
+	DeleteDelay                           string
 	LabeledNamespace                      string
 	KubernetesAddress                     string
 	KubernetesBearerToken                 string
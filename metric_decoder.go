@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	//protobuf delimited > OpenMetrics 1.0 > text 0.0.4
+	scrapeAcceptHeader = `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,application/openmetrics-text;version=1.0.0;q=0.5,text/plain;version=0.0.4;q=0.3,*/*;q=0.1`
+	openMetricsType    = "application/openmetrics-text"
+)
+
+// metricsFormat is the wire format of a scraped response.
+type metricsFormat int
+
+const (
+	formatText metricsFormat = iota
+	formatProtobuf
+	formatOpenMetrics
+)
+
+func detectMetricsFormat(header http.Header) metricsFormat {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return formatText
+	}
+	switch mediaType {
+	case expfmt.ProtoType:
+		if params["proto"] == expfmt.ProtoProtocol && params["encoding"] == "delimited" {
+			return formatProtobuf
+		}
+	case openMetricsType:
+		return formatOpenMetrics
+	}
+	return formatText
+}
+
+// unparsedMetricsError is returned if a text response is within the limits but can't be parsed, the raw body
+// is kept so that it can still be pushed as-is.
+type unparsedMetricsError struct {
+	Data  []byte
+	Cause error
+}
+
+func (e *unparsedMetricsError) Error() string {
+	return e.Cause.Error()
+}
+
+// decodeResponse decompresses (if needed) and decodes the scraped HTTP response with the limits enforced.
+func decodeResponse(rsp *http.Response, limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	var body io.Reader = rsp.Body
+	if strings.EqualFold(rsp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(rsp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	format := detectMetricsFormat(rsp.Header)
+	if format != formatText {
+		return decodeMetricFamilies(body, format, limits)
+	}
+	//the text is read in whole, since it is kept for the push gateway if it can't be parsed.
+	reader := &limitedReader{reader: body, limits: limits, countLines: true}
+	data, err := ioutil.ReadAll(reader)
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	families, err := decodeMetricFamilies(bytes.NewReader(data), format, limits)
+	if err != nil {
+		if _, ok := err.(*limitExceededError); !ok {
+			return nil, &unparsedMetricsError{Data: data, Cause: err}
+		}
+		return nil, err
+	}
+	return families, nil
+}
+
+// decodeMetricFamilies turns any supported format into metric families sorted by name.
+func decodeMetricFamilies(in io.Reader, format metricsFormat, limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	reader := &limitedReader{reader: in, limits: limits, countLines: format != formatProtobuf}
+	var families []*dto.MetricFamily
+	var err error
+	if format == formatProtobuf {
+		families, err = decodeProtobuf(reader, limits)
+	} else {
+		var source io.Reader = reader
+		var pr *io.PipeReader
+		var done chan struct{}
+		if format == formatOpenMetrics {
+			pr, done = openMetricsToText(reader)
+			source = pr
+		}
+		families, err = decodeText(source, limits)
+		if pr != nil {
+			//unblock the converting goroutine once the parser stopped.
+			pr.Close()
+			<-done
+		}
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	sortMetricFamilies(families)
+	return families, nil
+}
+
+func decodeProtobuf(in io.Reader, limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	decoder := expfmt.NewDecoder(in, expfmt.FmtProtoDelim)
+	families := []*dto.MetricFamily{}
+	samples := 0
+	for {
+		mf := &dto.MetricFamily{}
+		if err := decoder.Decode(mf); err != nil {
+			if err == io.EOF {
+				return families, nil
+			}
+			return nil, err
+		}
+		if err := checkSeriesLimits(mf, limits, &samples); err != nil {
+			return nil, err
+		}
+		families = append(families, mf)
+	}
+}
+
+func decodeText(in io.Reader, limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	parser := expfmt.TextParser{}
+	metrics, err := parser.TextToMetricFamilies(in)
+	if err != nil {
+		return nil, err
+	}
+	families := make([]*dto.MetricFamily, 0, len(metrics))
+	samples := 0
+	for _, mf := range metrics {
+		if err = checkSeriesLimits(mf, limits, &samples); err != nil {
+			return nil, err
+		}
+		families = append(families, mf)
+	}
+	return families, nil
+}
+
+func sortMetricFamilies(families []*dto.MetricFamily) {
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+}
+
+// openMetricsToText converts the OpenMetrics 1.0 exposition into the text format 0.0.4 line by line.
+func openMetricsToText(in io.Reader) (*io.PipeReader, chan struct{}) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		converter := &openMetricsConverter{types: map[string]string{}}
+		var metadata []string
+		flush := func() error {
+			for _, line := range converter.convertMetadata(metadata) {
+				if _, err := io.WriteString(pw, line+"\n"); err != nil {
+					return err
+				}
+			}
+			metadata = metadata[:0]
+			return nil
+		}
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			//metadata of a family can be in any order, so buffer them until the first sample shows up.
+			if strings.HasPrefix(line, "#") {
+				metadata = append(metadata, line)
+				continue
+			}
+			if err := flush(); err != nil {
+				return
+			}
+			if converted, ok := converter.convertSample(line); ok {
+				if _, err := io.WriteString(pw, converted+"\n"); err != nil {
+					return
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if err := flush(); err != nil {
+			return
+		}
+		pw.Close()
+	}()
+	return pr, done
+}
+
+type openMetricsConverter struct {
+	types map[string]string //family name -> OpenMetrics type
+}
+
+// convertMetadata renames counter & info families to their sample names and maps the types which the text format lacks.
+func (c *openMetricsConverter) convertMetadata(lines []string) []string {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && fields[1] == "TYPE" {
+			c.types[fields[2]] = fields[3]
+		}
+	}
+	converted := make([]string, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) < 3 || (fields[1] != "TYPE" && fields[1] != "HELP") {
+			//"# EOF", "# UNIT" and ordinary comments.
+			continue
+		}
+		name := fields[2]
+		omType := c.types[name]
+		switch omType {
+		case "counter":
+			fields[2] = name + "_total"
+		case "info":
+			fields[2] = name + "_info"
+		case "gaugehistogram":
+			//its samples ("_gcount", "_gsum") will be treated as untyped.
+			continue
+		}
+		if fields[1] == "TYPE" && len(fields) == 4 {
+			switch omType {
+			case "unknown":
+				fields[3] = "untyped"
+			case "stateset", "info":
+				fields[3] = "gauge"
+			}
+		}
+		converted = append(converted, strings.Join(fields, " "))
+	}
+	return converted
+}
+
+// convertSample strips the exemplar and converts the timestamp from seconds to milliseconds.
+func (c *openMetricsConverter) convertSample(line string) (string, bool) {
+	nameEnd := strings.IndexAny(line, "{ ")
+	if nameEnd < 0 {
+		return line, true
+	}
+	name := line[:nameEnd]
+	if strings.HasSuffix(name, "_created") {
+		switch c.types[strings.TrimSuffix(name, "_created")] {
+		case "counter", "histogram", "summary":
+			return "", false
+		}
+	}
+	labelsEnd := nameEnd
+	if line[nameEnd] == '{' {
+		labelsEnd = closingBraceIndex(line, nameEnd)
+		if labelsEnd < 0 {
+			return line, true
+		}
+		labelsEnd++
+	}
+	rest := line[labelsEnd:]
+	if idx := strings.Index(rest, " # "); idx >= 0 {
+		rest = rest[:idx]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 2 {
+		if ts, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			fields[1] = strconv.FormatInt(int64(ts*1000), 10)
+		}
+	}
+	return line[:labelsEnd] + " " + strings.Join(fields, " "), true
+}
+
+// closingBraceIndex returns the index of the "}" which closes the label set, quoted label values are skipped.
+func closingBraceIndex(line string, start int) int {
+	inQuotes := false
+	for i := start; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if inQuotes {
+				i++
+			}
+		case '"':
+			inQuotes = !inQuotes
+		case '}':
+			if !inQuotes {
+				return i
+			}
+		}
+	}
+	return -1
+}
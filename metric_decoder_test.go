@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDecodeResponseUnparsed(t *testing.T) {
+	body := "# TYPE up gauge\nup 1\nup{broken 1\n"
+	e, target := newTestTarget(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(body))
+	}), "prometheus", "")
+	_, err := fetchMetrics(&scrapeClient{timeout: e.Timeout}, e, target)
+	raw, ok := err.(*unparsedMetricsError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(raw.Data) != body {
+		t.Errorf("unexpected raw body: %q", raw.Data)
+	}
+	e.Limits.SampleLimit = 1
+	if _, err = fetchMetrics(&scrapeClient{timeout: e.Timeout}, e, target); err == nil {
+		t.Fatal("no error for the response exceeding the sample limit")
+	}
+	if _, ok = err.(*limitExceededError); !ok {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
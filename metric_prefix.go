@@ -0,0 +1,57 @@
+package main
+
+import (
+	dto "github.com/prometheus/client_model/go"
+	"strings"
+)
+
+// sanitizeMetricName replaces the invalid characters of a metric name with "_", e.g. "my-app" -> "my_app".
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName replaces the invalid characters of a label name with "_".
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, colonAllowed bool) string {
+	sb := strings.Builder{}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':' && colonAllowed:
+			sb.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(c)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// sanitizeMetricPrefix turns the annotated namespace into a valid metric name prefix.
+func sanitizeMetricPrefix(namespace string) string {
+	return sanitizeMetricName(strings.Trim(strings.TrimSpace(namespace), "_"))
+}
+
+// applyMetricPrefix renames every family as "<namespace>_<name>" like Collectbeat does,
+// the families which have already been prefixed are left untouched.
+func applyMetricPrefix(families []*dto.MetricFamily, namespace string) {
+	prefix := sanitizeMetricPrefix(namespace)
+	if prefix == "" {
+		return
+	}
+	prefix += "_"
+	for _, mf := range families {
+		if strings.HasPrefix(mf.GetName(), prefix) {
+			continue
+		}
+		name := prefix + mf.GetName()
+		mf.Name = &name
+	}
+	sortMetricFamilies(families)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func prefixedNames(t *testing.T, annotations map[string]string) []string {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	saved := args
+	t.Cleanup(func() { args = saved })
+	args = defineArgs(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	e := newReceiverEvent(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations}})
+	total, up := "myapp_requests_total", "up"
+	families := []*dto.MetricFamily{{Name: &total}, {Name: &up}}
+	applyMetricPrefix(families, e.LabeledNamespace)
+	names := []string{}
+	for _, mf := range families {
+		names = append(names, mf.GetName())
+	}
+	return names
+}
+
+func TestApplyMetricPrefix(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []string
+	}{
+		{name: "unannotated", want: []string{"myapp_requests_total", "up"}},
+		{name: "annotated", annotations: map[string]string{"io.collectbeat.metrics/namespace": "my-app"}, want: []string{"my_app_myapp_requests_total", "my_app_up"}},
+		{name: "already prefixed", annotations: map[string]string{"io.collectbeat.metrics/namespace": "myapp"}, want: []string{"myapp_requests_total", "myapp_up"}},
+	}
+	for _, test := range tests {
+		got := prefixedNames(t, test.annotations)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: unexpected names: %v", test.name, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: unexpected names: %v, want: %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
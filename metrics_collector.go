@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// metricsCollector fetches a target of the POD and converts its response into metric families.
+type metricsCollector struct {
+	Collect func(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error)
+	// ValidateConfig validates the type specific configuration, nil if the type has no configuration.
+	ValidateConfig func(config []byte) error
+	// RequiresConfig marks the types which CANNOT work without any configuration.
+	RequiresConfig bool
+	// DefaultPath is used if the endpoint has no path.
+	DefaultPath string
+}
+
+var (
+	//supported values of the "/type" annotation.
+	metricsCollectors = map[string]*metricsCollector{
+		"prometheus": {Collect: collectPrometheus, DefaultPath: "/metrics"},
+		"json":       {Collect: collectJSON, ValidateConfig: validateJSONMapping, RequiresConfig: true},
+		"jolokia":    {Collect: collectJolokia, ValidateConfig: validateJolokiaConfig, RequiresConfig: true, DefaultPath: "/jolokia"},
+		"expvar":     {Collect: collectExpvar, ValidateConfig: validateExpvarConfig, DefaultPath: "/debug/vars"},
+		"actuator":   {Collect: collectActuator, ValidateConfig: validateActuatorConfig, DefaultPath: "/actuator/metrics"},
+		"nginx":      {Collect: collectNginx, DefaultPath: "/stub_status"},
+		"apache":     {Collect: collectApache, DefaultPath: "/server-status?auto"},
+		"redis":      {Collect: collectRedis},
+	}
+)
+
+func isSupportedMetricType(metricType string) bool {
+	_, ok := metricsCollectors[metricType]
+	return ok
+}
+
+// fetchMetrics fetches the metrics of the target of the POD referred by the event with the collector of its type.
+func fetchMetrics(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error) {
+	collector, ok := metricsCollectors[e.MetricType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported metrics type \"%s\"", e.MetricType)
+	}
+	if err := c.prepare(e); err != nil {
+		return nil, fmt.Errorf("failed to prepare HTTP client: %s", err.Error())
+	}
+	//the numeric ports without any path are still scraped at "/" by the "prometheus" type.
+	if t.Path == "" && (t.Named || e.MetricType != "prometheus") {
+		t.Path = collector.DefaultPath
+	}
+	families, err := collector.Collect(c, e, t)
+	if err != nil {
+		return nil, err
+	}
+	applyMetricPrefix(families, e.LabeledNamespace)
+	return families, nil
+}
+
+func collectPrometheus(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error) {
+	url := t.URL(e.Scheme, scrapeHost(e))
+	log.Debugf("%#v", e.Pod.Status)
+	log.Debugf("Preparing to fetch metrics URL: %s, POD IP: %s", url, e.Pod.Status.PodIP)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.credentials.applyTo(req)
+	req.Header.Set("Accept", scrapeAcceptHeader)
+	//the response will be decompressed by ourselves since the header has been set explicitly.
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP response status code: %d", rsp.StatusCode)
+	}
+	return decodeResponse(rsp, e.Limits)
+}
+
+// fetchBody sends the request with the client & credentials, and reads the whole body within the body size limit.
+func fetchBody(c *scrapeClient, e *PODEvent, req *http.Request) ([]byte, error) {
+	c.credentials.applyTo(req)
+	log.Debugf("Preparing to fetch metrics URL: %s, POD IP: %s", req.URL.String(), e.Pod.Status.PodIP)
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP response status code: %d", rsp.StatusCode)
+	}
+	reader := &limitedReader{reader: rsp.Body, limits: e.Limits}
+	data, err := ioutil.ReadAll(reader)
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	return data, err
+}
+
+// getBody fetches the path of the target with a GET request.
+func getBody(c *scrapeClient, e *PODEvent, t scrapeTarget, accept string) ([]byte, error) {
+	req, err := http.NewRequest("GET", t.URL(e.Scheme, scrapeHost(e)), nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	return fetchBody(c, e, req)
+}
+
+// familyBuilder accumulates the converted samples into metric families, the duplicated series are dropped.
+type familyBuilder struct {
+	families map[string]*dto.MetricFamily
+	series   map[string]bool
+}
+
+func newFamilyBuilder() *familyBuilder {
+	return &familyBuilder{families: make(map[string]*dto.MetricFamily), series: make(map[string]bool)}
+}
+
+func (b *familyBuilder) add(name, help string, metricType dto.MetricType, value float64, labels map[string]string) {
+	family, ok := b.families[name]
+	if !ok {
+		family = &dto.MetricFamily{Name: &name, Type: metricType.Enum()}
+		if help != "" {
+			family.Help = &help
+		}
+		b.families[name] = family
+	} else if family.GetType() != metricType {
+		log.Debugf("Dropped sample of metric %s since its type conflicts with the previous ones.", name)
+		return
+	}
+	signature := seriesSignature(name, labels)
+	if b.series[signature] {
+		return
+	}
+	b.series[signature] = true
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	metric := &dto.Metric{}
+	for _, k := range keys {
+		k, v := k, labels[k]
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: &k, Value: &v})
+	}
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		metric.Counter = &dto.Counter{Value: &value}
+	case dto.MetricType_GAUGE:
+		metric.Gauge = &dto.Gauge{Value: &value}
+	default:
+		metric.Untyped = &dto.Untyped{Value: &value}
+	}
+	family.Metric = append(family.Metric, metric)
+}
+
+// seriesSignature identifies the series by its name & labels.
+func seriesSignature(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	signature := strings.Builder{}
+	signature.WriteString(name)
+	for _, k := range keys {
+		signature.WriteString("\xff" + k + "\xff" + labels[k])
+	}
+	return signature.String()
+}
+
+// labelPairsSignature identifies the series by its labels, which have been sorted by name.
+func labelPairsSignature(labels []*dto.LabelPair) string {
+	signature := strings.Builder{}
+	for _, l := range labels {
+		signature.WriteString(l.GetName() + "\xff" + l.GetValue() + "\xff")
+	}
+	return signature.String()
+}
+
+// sortedKeys returns the keys of the decoded JSON object in order, so that the conversion never depends on the map iteration.
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// build returns the families sorted by name with the series limits enforced, the series are sorted by their labels
+// so that the encoded payload is stable across scrapes (see the push deduplication).
+func (b *familyBuilder) build(limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	families := make([]*dto.MetricFamily, 0, len(b.families))
+	samples := 0
+	for _, mf := range b.families {
+		if err := checkSeriesLimits(mf, limits, &samples); err != nil {
+			return nil, err
+		}
+		signatures := make(map[*dto.Metric]string, len(mf.Metric))
+		for _, m := range mf.Metric {
+			signatures[m] = labelPairsSignature(m.Label)
+		}
+		sort.Slice(mf.Metric, func(i, j int) bool { return signatures[mf.Metric[i]] < signatures[mf.Metric[j]] })
+		families = append(families, mf)
+	}
+	sortMetricFamilies(families)
+	return families, nil
+}
+
+// parseMetricType parses the type of the configured metrics, gauge by default.
+func parseMetricType(value string) (dto.MetricType, error) {
+	switch strings.ToLower(value) {
+	case "", "gauge":
+		return dto.MetricType_GAUGE, nil
+	case "counter":
+		return dto.MetricType_COUNTER, nil
+	case "untyped":
+		return dto.MetricType_UNTYPED, nil
+	}
+	return dto.MetricType_UNTYPED, fmt.Errorf("unsupported metric type \"%s\"", value)
+}
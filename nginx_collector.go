@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	"strconv"
+	"strings"
+)
+
+func collectNginx(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error) {
+	body, err := getBody(c, e, t, "text/plain")
+	if err != nil {
+		return nil, err
+	}
+	return parseNginxStubStatus(string(body), e.Limits)
+}
+
+// parseNginxStubStatus converts the page of the "stub_status" module, which is formatted as
+//
+//	Active connections: 291
+//	server accepts handled requests
+//	 16630948 16630948 31070465
+//	Reading: 6 Writing: 179 Waiting: 106
+//
+// into the same metrics as the official nginx-prometheus-exporter.
+func parseNginxStubStatus(page string, limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	lines := strings.Split(strings.TrimSpace(page), "\n")
+	if len(lines) != 4 {
+		return nil, fmt.Errorf("invalid stub_status page, expected 4 lines but got %d", len(lines))
+	}
+	active, err := parseNginxFields(lines[0], "Active connections:")
+	if err != nil {
+		return nil, err
+	}
+	counters, err := parseNginxFields(lines[2])
+	if err != nil || len(counters) != 3 {
+		return nil, fmt.Errorf("invalid stub_status counters \"%s\"", strings.TrimSpace(lines[2]))
+	}
+	states, err := parseNginxFields(lines[3], "Reading:", "Writing:", "Waiting:")
+	if err != nil {
+		return nil, err
+	}
+	builder := newFamilyBuilder()
+	builder.add("nginx_connections_active", "Active client connections.", dto.MetricType_GAUGE, active[0], nil)
+	builder.add("nginx_connections_accepted", "Accepted client connections.", dto.MetricType_COUNTER, counters[0], nil)
+	builder.add("nginx_connections_handled", "Handled client connections.", dto.MetricType_COUNTER, counters[1], nil)
+	builder.add("nginx_http_requests_total", "Total http requests.", dto.MetricType_COUNTER, counters[2], nil)
+	builder.add("nginx_connections_reading", "Connections where NGINX is reading the request header.", dto.MetricType_GAUGE, states[0], nil)
+	builder.add("nginx_connections_writing", "Connections where NGINX is writing the response back to the client.", dto.MetricType_GAUGE, states[1], nil)
+	builder.add("nginx_connections_waiting", "Idle client connections.", dto.MetricType_GAUGE, states[2], nil)
+	return builder.build(limits)
+}
+
+// parseNginxFields parses the numbers of the line, each of them follows a label if any labels are given.
+func parseNginxFields(line string, labels ...string) ([]float64, error) {
+	fields := strings.Fields(line)
+	values := []float64{}
+	for i := 0; i < len(fields); i++ {
+		if len(labels) > 0 {
+			label := labels[len(values)]
+			//the label may consist of several words, e.g. "Active connections:".
+			words := strings.Fields(label)
+			if i+len(words) >= len(fields) || strings.Join(fields[i:i+len(words)], " ") != label {
+				return nil, fmt.Errorf("invalid stub_status line \"%s\", expected \"%s\"", strings.TrimSpace(line), label)
+			}
+			i += len(words)
+		}
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stub_status value \"%s\"", fields[i])
+		}
+		values = append(values, value)
+		if len(labels) > 0 && len(values) == len(labels) {
+			break
+		}
+	}
+	if len(labels) > 0 && len(values) != len(labels) {
+		return nil, fmt.Errorf("invalid stub_status line \"%s\"", strings.TrimSpace(line))
+	}
+	return values, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseNginxStubStatus(t *testing.T) {
+	tests := []struct {
+		page string
+		want string
+		err  string
+	}{
+		{
+			page: "Active connections: 291 \nserver accepts handled requests\n 16630948 16630948 31070465 \nReading: 6 Writing: 179 Waiting: 106 \n",
+			want: `# HELP nginx_connections_accepted Accepted client connections.
+# TYPE nginx_connections_accepted counter
+nginx_connections_accepted 1.6630948e+07
+# HELP nginx_connections_active Active client connections.
+# TYPE nginx_connections_active gauge
+nginx_connections_active 291
+# HELP nginx_connections_handled Handled client connections.
+# TYPE nginx_connections_handled counter
+nginx_connections_handled 1.6630948e+07
+# HELP nginx_connections_reading Connections where NGINX is reading the request header.
+# TYPE nginx_connections_reading gauge
+nginx_connections_reading 6
+# HELP nginx_connections_waiting Idle client connections.
+# TYPE nginx_connections_waiting gauge
+nginx_connections_waiting 106
+# HELP nginx_connections_writing Connections where NGINX is writing the response back to the client.
+# TYPE nginx_connections_writing gauge
+nginx_connections_writing 179
+# HELP nginx_http_requests_total Total http requests.
+# TYPE nginx_http_requests_total counter
+nginx_http_requests_total 3.1070465e+07
+`,
+		},
+		{page: "<html>Not Found</html>", err: "expected 4 lines"},
+		{page: "Active: 1\nserver accepts handled requests\n 1 1 1\nReading: 0 Writing: 1 Waiting: 0\n", err: "expected \"Active connections:\""},
+		{page: "Active connections: 1\nserver accepts handled requests\n 1 1\nReading: 0 Writing: 1 Waiting: 0\n", err: "invalid stub_status counters"},
+		{page: "Active connections: 1\nserver accepts handled requests\n 1 1 1\nReading: 0 Writing: x Waiting: 0\n", err: "invalid stub_status value \"x\""},
+		{page: "Active connections: 1\nserver accepts handled requests\n 1 1 1\nReading: 0 Writing: 1\n", err: "invalid stub_status line"},
+	}
+	for _, test := range tests {
+		families, err := parseNginxStubStatus(test.page, scrapeLimits{})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: unexpected error: %v, want: %s", test.page, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %s", test.page, err.Error())
+		}
+		if got := encodeForTest(t, families); got != test.want {
+			t.Errorf("unexpected metrics:\n%s\nwant:\n%s", got, test.want)
+		}
+	}
+}
+
+func TestCollectNginx(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stub_status" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("Active connections: 2\nserver accepts handled requests\n 3 3 5\nReading: 0 Writing: 1 Waiting: 1\n"))
+	})
+	e, target := newTestTarget(t, handler, "nginx", "")
+	if got := collectForTest(t, e, target); !strings.Contains(got, "nginx_http_requests_total 5\n") {
+		t.Errorf("unexpected metrics:\n%s", got)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// kinds of the OTLP metrics, the exponential histograms are NOT supported.
+const (
+	otlpGauge       = "gauge"
+	otlpSum         = "sum"
+	otlpHistogram   = "histogram"
+	otlpSummary     = "summary"
+	otlpUnsupported = "unsupported"
+
+	otlpTemporalityDelta      = 1
+	otlpTemporalityCumulative = 2
+)
+
+// otlpResource is the decoded "ResourceMetrics" of an OTLP export request, ONLY the fields used by the bridge are kept.
+type otlpResource struct {
+	Attributes map[string]string
+	Metrics    []*otlpMetric
+}
+
+type otlpMetric struct {
+	Name        string
+	Description string
+	Unit        string
+	Kind        string
+	Monotonic   bool
+	Temporality int
+	Points      []*otlpPoint
+}
+
+type otlpPoint struct {
+	Attributes map[string]string
+	StartTime  uint64
+	Value      float64
+	Count      uint64
+	Sum        float64
+	Bounds     []float64
+	Buckets    []uint64
+	Quantiles  []otlpQuantile
+}
+
+type otlpQuantile struct {
+	Quantile float64
+	Value    float64
+}
+
+// protoField is a field of a protobuf message, the scalar values are kept in Uint while the length-delimited ones are kept in Bytes.
+type protoField struct {
+	Number int
+	Wire   int
+	Uint   uint64
+	Bytes  []byte
+}
+
+func (f protoField) double() float64 {
+	return math.Float64frombits(f.Uint)
+}
+
+// decodeProtoFields walks the fields of a protobuf message encoded in the wire format.
+func decodeProtoFields(data []byte, fn func(f protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("invalid protobuf field key")
+		}
+		data = data[n:]
+		f := protoField{Number: int(key >> 3), Wire: int(key & 7)}
+		switch f.Wire {
+		case 0:
+			if f.Uint, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("invalid protobuf varint of field %d", f.Number)
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return fmt.Errorf("truncated protobuf fixed64 of field %d", f.Number)
+			}
+			f.Uint, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return fmt.Errorf("truncated protobuf bytes of field %d", f.Number)
+			}
+			f.Bytes, data = data[n:n+int(size)], data[n+int(size):]
+		case 5:
+			if len(data) < 4 {
+				return fmt.Errorf("truncated protobuf fixed32 of field %d", f.Number)
+			}
+			f.Uint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d of field %d", f.Wire, f.Number)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixed64s returns the values of a repeated fixed64 (or double) field, which is either packed or not.
+func (f protoField) fixed64s() ([]uint64, error) {
+	if f.Wire == 1 {
+		return []uint64{f.Uint}, nil
+	}
+	if f.Wire != 2 || len(f.Bytes)%8 != 0 {
+		return nil, fmt.Errorf("invalid repeated fixed64 field %d", f.Number)
+	}
+	values := make([]uint64, 0, len(f.Bytes)/8)
+	for i := 0; i < len(f.Bytes); i += 8 {
+		values = append(values, binary.LittleEndian.Uint64(f.Bytes[i:]))
+	}
+	return values, nil
+}
+
+// decodeOTLPProtobuf decodes the "ExportMetricsServiceRequest" message.
+func decodeOTLPProtobuf(data []byte) ([]*otlpResource, error) {
+	resources := []*otlpResource{}
+	err := decodeProtoFields(data, func(f protoField) error {
+		if f.Number != 1 {
+			return nil
+		}
+		resource, err := decodeOTLPResourceMetrics(f.Bytes)
+		resources = append(resources, resource)
+		return err
+	})
+	return resources, err
+}
+
+func decodeOTLPResourceMetrics(data []byte) (*otlpResource, error) {
+	resource := &otlpResource{Attributes: map[string]string{}}
+	err := decodeProtoFields(data, func(f protoField) error {
+		switch f.Number {
+		case 1: //resource
+			return decodeProtoFields(f.Bytes, func(f protoField) error {
+				if f.Number == 1 {
+					return decodeOTLPKeyValue(f.Bytes, resource.Attributes)
+				}
+				return nil
+			})
+		case 2, 1000: //scope_metrics, or instrumentation_library_metrics of the earlier versions.
+			return decodeProtoFields(f.Bytes, func(f protoField) error {
+				if f.Number != 2 {
+					return nil
+				}
+				metric, err := decodeOTLPMetric(f.Bytes)
+				resource.Metrics = append(resource.Metrics, metric)
+				return err
+			})
+		}
+		return nil
+	})
+	return resource, err
+}
+
+func decodeOTLPKeyValue(data []byte, attributes map[string]string) error {
+	key, value, ok := "", "", false
+	err := decodeProtoFields(data, func(f protoField) error {
+		switch f.Number {
+		case 1:
+			key = string(f.Bytes)
+		case 2:
+			return decodeProtoFields(f.Bytes, func(f protoField) error {
+				switch f.Number {
+				case 1:
+					value, ok = string(f.Bytes), true
+				case 2:
+					value, ok = strconv.FormatBool(f.Uint != 0), true
+				case 3:
+					value, ok = strconv.FormatInt(int64(f.Uint), 10), true
+				case 4:
+					value, ok = strconv.FormatFloat(f.double(), 'f', -1, 64), true
+				}
+				//the arrays, key-value lists & bytes are ignored.
+				return nil
+			})
+		}
+		return nil
+	})
+	if ok {
+		attributes[key] = value
+	}
+	return err
+}
+
+func decodeOTLPMetric(data []byte) (*otlpMetric, error) {
+	metric := &otlpMetric{Kind: otlpUnsupported}
+	err := decodeProtoFields(data, func(f protoField) error {
+		switch f.Number {
+		case 1:
+			metric.Name = string(f.Bytes)
+		case 2:
+			metric.Description = string(f.Bytes)
+		case 3:
+			metric.Unit = string(f.Bytes)
+		case 5:
+			metric.Kind = otlpGauge
+			return decodeOTLPData(f.Bytes, metric, decodeOTLPNumberPoint)
+		case 7:
+			metric.Kind = otlpSum
+			return decodeOTLPData(f.Bytes, metric, decodeOTLPNumberPoint)
+		case 9:
+			metric.Kind = otlpHistogram
+			return decodeOTLPData(f.Bytes, metric, decodeOTLPHistogramPoint)
+		case 11:
+			metric.Kind = otlpSummary
+			return decodeOTLPData(f.Bytes, metric, decodeOTLPSummaryPoint)
+		}
+		return nil
+	})
+	return metric, err
+}
+
+// decodeOTLPData decodes the "Gauge", "Sum", "Histogram" & "Summary" messages, which share the same field numbers.
+func decodeOTLPData(data []byte, metric *otlpMetric, decodePoint func([]byte) (*otlpPoint, error)) error {
+	return decodeProtoFields(data, func(f protoField) error {
+		switch f.Number {
+		case 1:
+			point, err := decodePoint(f.Bytes)
+			metric.Points = append(metric.Points, point)
+			return err
+		case 2:
+			metric.Temporality = int(f.Uint)
+		case 3:
+			metric.Monotonic = f.Uint != 0
+		}
+		return nil
+	})
+}
+
+func decodeOTLPNumberPoint(data []byte) (*otlpPoint, error) {
+	point := &otlpPoint{Attributes: map[string]string{}}
+	err := decodeProtoFields(data, func(f protoField) error {
+		switch f.Number {
+		case 7:
+			return decodeOTLPKeyValue(f.Bytes, point.Attributes)
+		case 2:
+			point.StartTime = f.Uint
+		case 4:
+			point.Value = f.double()
+		case 6:
+			point.Value = float64(int64(f.Uint))
+		}
+		return nil
+	})
+	return point, err
+}
+
+func decodeOTLPHistogramPoint(data []byte) (*otlpPoint, error) {
+	point := &otlpPoint{Attributes: map[string]string{}}
+	err := decodeProtoFields(data, func(f protoField) error {
+		switch f.Number {
+		case 9:
+			return decodeOTLPKeyValue(f.Bytes, point.Attributes)
+		case 2:
+			point.StartTime = f.Uint
+		case 4:
+			point.Count = f.Uint
+		case 5:
+			point.Sum = f.double()
+		case 6:
+			counts, err := f.fixed64s()
+			point.Buckets = append(point.Buckets, counts...)
+			return err
+		case 7:
+			bounds, err := f.fixed64s()
+			for _, b := range bounds {
+				point.Bounds = append(point.Bounds, math.Float64frombits(b))
+			}
+			return err
+		}
+		return nil
+	})
+	return point, err
+}
+
+func decodeOTLPSummaryPoint(data []byte) (*otlpPoint, error) {
+	point := &otlpPoint{Attributes: map[string]string{}}
+	err := decodeProtoFields(data, func(f protoField) error {
+		switch f.Number {
+		case 7:
+			return decodeOTLPKeyValue(f.Bytes, point.Attributes)
+		case 2:
+			point.StartTime = f.Uint
+		case 4:
+			point.Count = f.Uint
+		case 5:
+			point.Sum = f.double()
+		case 6:
+			q := otlpQuantile{}
+			err := decodeProtoFields(f.Bytes, func(f protoField) error {
+				switch f.Number {
+				case 1:
+					q.Quantile = f.double()
+				case 2:
+					q.Value = f.double()
+				}
+				return nil
+			})
+			point.Quantiles = append(point.Quantiles, q)
+			return err
+		}
+		return nil
+	})
+	return point, err
+}
+
+// otlpJSONNumber accepts both the JSON numbers and the strings, since the 64-bit integers are encoded as strings in OTLP/JSON.
+type otlpJSONNumber string
+
+func (n *otlpJSONNumber) UnmarshalJSON(data []byte) error {
+	*n = otlpJSONNumber(strings.Trim(string(data), "\""))
+	return nil
+}
+
+func (n otlpJSONNumber) float() float64 {
+	f, _ := strconv.ParseFloat(string(n), 64)
+	return f
+}
+
+func (n otlpJSONNumber) uint() uint64 {
+	u, err := strconv.ParseUint(string(n), 10, 64)
+	if err != nil {
+		return uint64(n.float())
+	}
+	return u
+}
+
+// otlpJSONTemporality accepts both the integer & the name of the enum.
+type otlpJSONTemporality int
+
+func (t *otlpJSONTemporality) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), "\"")
+	switch {
+	case strings.HasSuffix(value, "DELTA"):
+		*t = otlpTemporalityDelta
+	case strings.HasSuffix(value, "CUMULATIVE"):
+		*t = otlpTemporalityCumulative
+	default:
+		i, _ := strconv.Atoi(value)
+		*t = otlpJSONTemporality(i)
+	}
+	return nil
+}
+
+type otlpJSONRequest struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes []otlpJSONKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics                  []otlpJSONScopeMetrics `json:"scopeMetrics"`
+		InstrumentationLibraryMetrics []otlpJSONScopeMetrics `json:"instrumentationLibraryMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+type otlpJSONScopeMetrics struct {
+	Metrics []struct {
+		Name                 string           `json:"name"`
+		Description          string           `json:"description"`
+		Unit                 string           `json:"unit"`
+		Gauge                *otlpJSONData    `json:"gauge"`
+		Sum                  *otlpJSONData    `json:"sum"`
+		Histogram            *otlpJSONData    `json:"histogram"`
+		Summary              *otlpJSONData    `json:"summary"`
+		ExponentialHistogram *json.RawMessage `json:"exponentialHistogram"`
+	} `json:"metrics"`
+}
+
+type otlpJSONData struct {
+	DataPoints []struct {
+		Attributes        []otlpJSONKeyValue `json:"attributes"`
+		StartTimeUnixNano otlpJSONNumber     `json:"startTimeUnixNano"`
+		AsDouble          *otlpJSONNumber    `json:"asDouble"`
+		AsInt             *otlpJSONNumber    `json:"asInt"`
+		Count             otlpJSONNumber     `json:"count"`
+		Sum               otlpJSONNumber     `json:"sum"`
+		BucketCounts      []otlpJSONNumber   `json:"bucketCounts"`
+		ExplicitBounds    []otlpJSONNumber   `json:"explicitBounds"`
+		QuantileValues    []struct {
+			Quantile otlpJSONNumber `json:"quantile"`
+			Value    otlpJSONNumber `json:"value"`
+		} `json:"quantileValues"`
+	} `json:"dataPoints"`
+	AggregationTemporality otlpJSONTemporality `json:"aggregationTemporality"`
+	IsMonotonic            bool                `json:"isMonotonic"`
+}
+
+type otlpJSONKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string         `json:"stringValue"`
+		BoolValue   *bool           `json:"boolValue"`
+		IntValue    *otlpJSONNumber `json:"intValue"`
+		DoubleValue *otlpJSONNumber `json:"doubleValue"`
+	} `json:"value"`
+}
+
+func otlpJSONAttributes(kvs []otlpJSONKeyValue) map[string]string {
+	attributes := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		switch {
+		case kv.Value.StringValue != nil:
+			attributes[kv.Key] = *kv.Value.StringValue
+		case kv.Value.BoolValue != nil:
+			attributes[kv.Key] = strconv.FormatBool(*kv.Value.BoolValue)
+		case kv.Value.IntValue != nil:
+			attributes[kv.Key] = string(*kv.Value.IntValue)
+		case kv.Value.DoubleValue != nil:
+			attributes[kv.Key] = string(*kv.Value.DoubleValue)
+		}
+	}
+	return attributes
+}
+
+// decodeOTLPJSON decodes the "ExportMetricsServiceRequest" message encoded in OTLP/JSON.
+func decodeOTLPJSON(data []byte) ([]*otlpResource, error) {
+	request := &otlpJSONRequest{}
+	if err := json.Unmarshal(data, request); err != nil {
+		return nil, err
+	}
+	resources := []*otlpResource{}
+	for _, rm := range request.ResourceMetrics {
+		resource := &otlpResource{Attributes: otlpJSONAttributes(rm.Resource.Attributes)}
+		for _, sm := range append(rm.ScopeMetrics, rm.InstrumentationLibraryMetrics...) {
+			for _, m := range sm.Metrics {
+				metric := &otlpMetric{Name: m.Name, Description: m.Description, Unit: m.Unit, Kind: otlpUnsupported}
+				data := m.Gauge
+				switch {
+				case m.Gauge != nil:
+					metric.Kind = otlpGauge
+				case m.Sum != nil:
+					metric.Kind, data = otlpSum, m.Sum
+				case m.Histogram != nil:
+					metric.Kind, data = otlpHistogram, m.Histogram
+				case m.Summary != nil:
+					metric.Kind, data = otlpSummary, m.Summary
+				}
+				if data != nil {
+					metric.Temporality, metric.Monotonic = int(data.AggregationTemporality), data.IsMonotonic
+					for _, p := range data.DataPoints {
+						point := &otlpPoint{Attributes: otlpJSONAttributes(p.Attributes), StartTime: p.StartTimeUnixNano.uint(), Count: p.Count.uint(), Sum: p.Sum.float()}
+						if p.AsDouble != nil {
+							point.Value = p.AsDouble.float()
+						} else if p.AsInt != nil {
+							point.Value = p.AsInt.float()
+						}
+						for _, c := range p.BucketCounts {
+							point.Buckets = append(point.Buckets, c.uint())
+						}
+						for _, b := range p.ExplicitBounds {
+							point.Bounds = append(point.Bounds, b.float())
+						}
+						for _, q := range p.QuantileValues {
+							point.Quantiles = append(point.Quantiles, otlpQuantile{Quantile: q.Quantile.float(), Value: q.Value.float()})
+						}
+						metric.Points = append(metric.Points, point)
+					}
+				}
+				resource.Metrics = append(resource.Metrics, metric)
+			}
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
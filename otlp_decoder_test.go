@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// protoMessage encodes a protobuf message in the wire format for the tests.
+type protoMessage []byte
+
+func (m protoMessage) key(number, wire int) protoMessage {
+	return binary.AppendUvarint(m, uint64(number<<3|wire))
+}
+
+func (m protoMessage) varint(number int, v uint64) protoMessage {
+	return binary.AppendUvarint(m.key(number, 0), v)
+}
+
+func (m protoMessage) fixed64(number int, v uint64) protoMessage {
+	return binary.LittleEndian.AppendUint64(m.key(number, 1), v)
+}
+
+func (m protoMessage) double(number int, v float64) protoMessage {
+	return m.fixed64(number, math.Float64bits(v))
+}
+
+func (m protoMessage) bytes(number int, b []byte) protoMessage {
+	return append(binary.AppendUvarint(m.key(number, 2), uint64(len(b))), b...)
+}
+
+func (m protoMessage) str(number int, s string) protoMessage {
+	return m.bytes(number, []byte(s))
+}
+
+func (m protoMessage) message(number int, sub protoMessage) protoMessage {
+	return m.bytes(number, sub)
+}
+
+func protoKeyValue(key string, value protoMessage) protoMessage {
+	return protoMessage{}.str(1, key).message(2, value)
+}
+
+// otlpTestResources is the expected result of decoding both otlpTestProtobuf() & otlpTestJSON.
+var otlpTestResources = []*otlpResource{{
+	Attributes: map[string]string{"k8s.pod.uid": "uid-1", "service.name": "app"},
+	Metrics: []*otlpMetric{
+		{Name: "http.requests", Description: "Requests.", Unit: "1", Kind: otlpSum, Monotonic: true, Temporality: otlpTemporalityDelta, Points: []*otlpPoint{
+			{Attributes: map[string]string{"method": "GET", "ok": "true"}, StartTime: 100, Value: 3},
+		}},
+		{Name: "temperature", Kind: otlpGauge, Points: []*otlpPoint{
+			{Attributes: map[string]string{}, Value: -1.5},
+		}},
+		{Name: "latency", Unit: "ms", Kind: otlpHistogram, Temporality: otlpTemporalityCumulative, Points: []*otlpPoint{
+			{Attributes: map[string]string{"ratio": "1.5"}, Count: 4, Sum: 10.5, Bounds: []float64{1, 5}, Buckets: []uint64{1, 2, 1}},
+		}},
+		{Name: "gc", Kind: otlpSummary, Points: []*otlpPoint{
+			{Attributes: map[string]string{"gen": "2"}, Count: 2, Sum: 3, Quantiles: []otlpQuantile{{Quantile: 0.5, Value: 1}, {Quantile: 0.99, Value: 2}}},
+		}},
+		{Name: "sizes", Kind: otlpUnsupported},
+	},
+}}
+
+func otlpTestProtobuf() []byte {
+	packed := func(values ...uint64) []byte {
+		b := []byte{}
+		for _, v := range values {
+			b = binary.LittleEndian.AppendUint64(b, v)
+		}
+		return b
+	}
+	sum := protoMessage{}.
+		message(1, protoMessage{}.
+			fixed64(2, 100).
+			fixed64(6, 3).
+			message(7, protoKeyValue("method", protoMessage{}.str(1, "GET"))).
+			message(7, protoKeyValue("ok", protoMessage{}.varint(2, 1)))).
+		varint(2, otlpTemporalityDelta).
+		varint(3, 1)
+	gauge := protoMessage{}.message(1, protoMessage{}.double(4, -1.5))
+	histogram := protoMessage{}.
+		message(1, protoMessage{}.
+			fixed64(4, 4).
+			double(5, 10.5).
+			bytes(6, packed(1, 2, 1)).
+			bytes(7, packed(math.Float64bits(1), math.Float64bits(5))).
+			message(9, protoKeyValue("ratio", protoMessage{}.double(4, 1.5)))).
+		varint(2, otlpTemporalityCumulative)
+	summary := protoMessage{}.
+		message(1, protoMessage{}.
+			fixed64(4, 2).
+			double(5, 3).
+			message(6, protoMessage{}.double(1, 0.5).double(2, 1)).
+			message(6, protoMessage{}.double(1, 0.99).double(2, 2)).
+			message(7, protoKeyValue("gen", protoMessage{}.varint(3, 2))))
+	scope := protoMessage{}.
+		message(1, protoMessage{}.str(1, "io.opentelemetry")).
+		message(2, protoMessage{}.str(1, "http.requests").str(2, "Requests.").str(3, "1").message(7, sum)).
+		message(2, protoMessage{}.str(1, "temperature").message(5, gauge)).
+		message(2, protoMessage{}.str(1, "latency").str(3, "ms").message(9, histogram)).
+		message(2, protoMessage{}.str(1, "gc").message(11, summary)).
+		message(2, protoMessage{}.str(1, "sizes").message(10, protoMessage{}.varint(2, otlpTemporalityDelta)))
+	resource := protoMessage{}.
+		message(1, protoKeyValue("k8s.pod.uid", protoMessage{}.str(1, "uid-1"))).
+		message(1, protoKeyValue("service.name", protoMessage{}.str(1, "app"))).
+		message(1, protoKeyValue("ignored", protoMessage{}.message(5, protoMessage{})))
+	return protoMessage{}.message(1, protoMessage{}.message(1, resource).message(2, scope))
+}
+
+const otlpTestJSON = `{"resourceMetrics": [{
+	"resource": {"attributes": [
+		{"key": "k8s.pod.uid", "value": {"stringValue": "uid-1"}},
+		{"key": "service.name", "value": {"stringValue": "app"}},
+		{"key": "ignored", "value": {"arrayValue": {}}}
+	]},
+	"scopeMetrics": [{"scope": {"name": "io.opentelemetry"}, "metrics": [
+		{"name": "http.requests", "description": "Requests.", "unit": "1", "sum": {
+			"dataPoints": [{"startTimeUnixNano": "100", "asInt": "3", "attributes": [
+				{"key": "method", "value": {"stringValue": "GET"}},
+				{"key": "ok", "value": {"boolValue": true}}
+			]}],
+			"aggregationTemporality": "AGGREGATION_TEMPORALITY_DELTA", "isMonotonic": true
+		}},
+		{"name": "temperature", "gauge": {"dataPoints": [{"asDouble": -1.5}]}},
+		{"name": "latency", "unit": "ms", "histogram": {
+			"dataPoints": [{"count": "4", "sum": 10.5, "bucketCounts": ["1", "2", "1"], "explicitBounds": [1, 5], "attributes": [
+				{"key": "ratio", "value": {"doubleValue": 1.5}}
+			]}],
+			"aggregationTemporality": 2
+		}},
+		{"name": "gc", "summary": {"dataPoints": [{"count": "2", "sum": 3, "quantileValues": [
+			{"quantile": 0.5, "value": 1}, {"quantile": 0.99, "value": 2}
+		], "attributes": [{"key": "gen", "value": {"intValue": "2"}}]}]}},
+		{"name": "sizes", "exponentialHistogram": {"aggregationTemporality": 1}}
+	]}]
+}]}`
+
+func checkOTLPResources(t *testing.T, got []*otlpResource) {
+	if !reflect.DeepEqual(got, otlpTestResources) {
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(otlpTestResources)
+		t.Errorf("unexpected resources:\n%s\nwant:\n%s", gotJSON, wantJSON)
+	}
+}
+
+func TestDecodeOTLPProtobuf(t *testing.T) {
+	resources, err := decodeOTLPProtobuf(otlpTestProtobuf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOTLPResources(t, resources)
+}
+
+func TestDecodeOTLPJSON(t *testing.T) {
+	resources, err := decodeOTLPJSON([]byte(otlpTestJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOTLPResources(t, resources)
+}
+
+func TestDecodeOTLPProtobufTruncated(t *testing.T) {
+	data := otlpTestProtobuf()
+	for _, size := range []int{1, 10, len(data) - 1} {
+		if _, err := decodeOTLPProtobuf(data[:size]); err == nil {
+			t.Errorf("no error for the request truncated to %d bytes", size)
+		}
+	}
+}
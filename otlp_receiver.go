@@ -0,0 +1,394 @@
+package main
+
+import (
+	"compress/gzip"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otlpSource          = "otlp"
+	otlpMetricsPath     = "/v1/metrics"
+	otlpPodUIDAttribute = "k8s.pod.uid"
+	//the series which have NOT been updated for the flushes are dropped, like the stale attribute combinations.
+	otlpSeriesIdleFlushes = 5
+)
+
+var (
+	//suffixes appended to the metric names by the units, like the Prometheus exporter of OpenTelemetry does.
+	otlpUnitSuffixes = map[string]string{
+		"s":    "seconds",
+		"ms":   "milliseconds",
+		"us":   "microseconds",
+		"ns":   "nanoseconds",
+		"By":   "bytes",
+		"KiBy": "kibibytes",
+		"MiBy": "mebibytes",
+		"%":    "percent",
+	}
+	otlpDataPointCounter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "crystal_bridge_otlp_data_points_total", Help: "Total count of the received OTLP data points by result."}, []string{"result"})
+)
+
+// otlpSeries is the latest state of a series, the delta data points are accumulated into it.
+type otlpSeries struct {
+	Name      string
+	Help      string
+	Type      dto.MetricType
+	Labels    map[string]string
+	Value     float64
+	Count     uint64
+	Sum       float64
+	Bounds    []float64
+	Buckets   []uint64
+	Quantiles []otlpQuantile
+	Idle      int
+}
+
+type otlpPodState struct {
+	Pod    *corev1.Pod
+	Series map[string]*otlpSeries
+	Types  map[string]dto.MetricType
+}
+
+// otlpReceiver receives the OTLP/HTTP metrics exported to the node, and attributes them to the PODs by
+// the "k8s.pod.uid" resource attributes or the source IPs.
+type otlpReceiver struct {
+	interval time.Duration
+	lock     sync.Mutex
+	pods     map[types.UID]*otlpPodState
+}
+
+func initializeOTLPReceiver() {
+	if !args.EnableOTLPReceiver {
+		return
+	}
+	log.Infof("Initializing OTLP receiver on %s...", otlpMetricsPath)
+	prometheus.MustRegister(otlpDataPointCounter)
+	interval, err := parsePositiveDuration(args.OTLPFlushInterval)
+	if err != nil {
+		log.Panicf("Invalid OTLP flush interval \"%s\", error: %s", args.OTLPFlushInterval, err.Error())
+	}
+	r := &otlpReceiver{interval: interval, pods: map[types.UID]*otlpPodState{}}
+	//served on the same port as the bridge's own metrics.
+	http.HandleFunc(otlpMetricsPath, r.handleExport)
+	go r.run()
+}
+
+func (r *otlpReceiver) handleExport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "ONLY POST is allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer reader.Close()
+		body = reader
+	}
+	limited := &limitedReader{reader: body, limits: args.ScrapeLimits}
+	data, err := ioutil.ReadAll(limited)
+	if limited.err != nil {
+		http.Error(w, limited.err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	isJSON := strings.HasPrefix(req.Header.Get("Content-Type"), "application/json")
+	var resources []*otlpResource
+	if isJSON {
+		resources, err = decodeOTLPJSON(data)
+	} else {
+		resources, err = decodeOTLPProtobuf(data)
+	}
+	if err != nil {
+		log.Debugf("Rejected invalid OTLP request from %s, error: %s", req.RemoteAddr, err.Error())
+		http.Error(w, "invalid OTLP request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !r.receive(req.RemoteAddr, resources) {
+		http.Error(w, "the sender is NOT a POD on current node.", http.StatusForbidden)
+		return
+	}
+	//an empty "ExportMetricsServiceResponse" means full success.
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}
+}
+
+// receive aggregates the resources into the states of their PODs, false will be returned if none of them can be attributed.
+func (r *otlpReceiver) receive(remote string, resources []*otlpResource) bool {
+	attributed := false
+	sender := podByIP(remote)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, resource := range resources {
+		points := 0
+		for _, metric := range resource.Metrics {
+			points += len(metric.Points)
+		}
+		pod := attributeOTLPResource(remote, sender, resource)
+		if pod == nil {
+			otlpDataPointCounter.WithLabelValues("unattributed").Add(float64(points))
+			log.Debugf("Dropped %d OTLP data points from %s which are NOT owned by any POD on current node.", points, remote)
+			continue
+		}
+		attributed = true
+		state, ok := r.pods[pod.UID]
+		if !ok {
+			state = &otlpPodState{Series: map[string]*otlpSeries{}, Types: map[string]dto.MetricType{}}
+			r.pods[pod.UID] = state
+		}
+		state.Pod = pod
+		for _, metric := range resource.Metrics {
+			for _, point := range metric.Points {
+				if state.aggregate(metric, point) {
+					otlpDataPointCounter.WithLabelValues("accepted").Inc()
+				} else {
+					otlpDataPointCounter.WithLabelValues("dropped").Inc()
+				}
+			}
+		}
+	}
+	return attributed || len(resources) == 0
+}
+
+// attributeOTLPResource returns the POD which owns the resource. The "k8s.pod.uid" attribute is honored ONLY if it is
+// the sender itself, or the sender is an agent on the node (e.g. a collector running with hostNetwork), so that nobody
+// else can push into the grouping keys of other PODs. Otherwise, the resource is owned by the sender.
+func attributeOTLPResource(remote string, sender *corev1.Pod, resource *otlpResource) *corev1.Pod {
+	uid := resource.Attributes[otlpPodUIDAttribute]
+	if uid == "" || (sender != nil && string(sender.UID) == uid) {
+		return sender
+	}
+	if sender == nil {
+		if pod := podByUID(uid); pod != nil && isNodeAddress(remote, pod.Status.HostIP) {
+			return pod
+		}
+	}
+	return sender
+}
+
+// otlpMetricName converts the OTLP name into a Prometheus one with the unit & "_total" suffixes.
+func otlpMetricName(metric *otlpMetric, metricType dto.MetricType) string {
+	name := strings.TrimSuffix(sanitizeMetricName(metric.Name), "_total")
+	if unit, ok := otlpUnitSuffixes[metric.Unit]; ok && !strings.HasSuffix(name, "_"+unit) {
+		name += "_" + unit
+	}
+	if metricType == dto.MetricType_COUNTER {
+		name += "_total"
+	}
+	return name
+}
+
+func (state *otlpPodState) aggregate(metric *otlpMetric, point *otlpPoint) bool {
+	var metricType dto.MetricType
+	switch metric.Kind {
+	case otlpGauge:
+		metricType = dto.MetricType_GAUGE
+	case otlpSum:
+		metricType = dto.MetricType_GAUGE
+		if metric.Monotonic {
+			metricType = dto.MetricType_COUNTER
+		}
+	case otlpHistogram:
+		metricType = dto.MetricType_HISTOGRAM
+	case otlpSummary:
+		metricType = dto.MetricType_SUMMARY
+	default:
+		return false
+	}
+	name := otlpMetricName(metric, metricType)
+	if t, ok := state.Types[name]; ok && t != metricType {
+		return false
+	}
+	labels := make(map[string]string, len(point.Attributes))
+	for k, v := range point.Attributes {
+		if k = sanitizeLabelName(k); !isGroupingLabel(k) {
+			labels[k] = v
+		}
+	}
+	if args.ScrapeLimits.LabelLimit > 0 && len(labels) > args.ScrapeLimits.LabelLimit {
+		return false
+	}
+	key := seriesSignature(name, labels)
+	series, ok := state.Series[key]
+	if !ok {
+		if args.ScrapeLimits.SampleLimit > 0 && len(state.Series) >= args.ScrapeLimits.SampleLimit {
+			return false
+		}
+		series = &otlpSeries{Name: name, Help: metric.Description, Type: metricType, Labels: labels}
+		state.Series[key] = series
+		state.Types[name] = metricType
+	}
+	series.Idle = 0
+	delta := metric.Temporality == otlpTemporalityDelta
+	switch metric.Kind {
+	case otlpGauge:
+		series.Value = point.Value
+	case otlpSum:
+		if delta {
+			series.Value += point.Value
+		} else {
+			series.Value = point.Value
+		}
+	case otlpHistogram:
+		if len(point.Buckets) != len(point.Bounds)+1 {
+			//the buckets are optional, ONLY the count & sum are kept.
+			point.Bounds, point.Buckets = nil, nil
+		}
+		if !delta || !equalBounds(series.Bounds, point.Bounds) || len(series.Buckets) != len(point.Buckets) {
+			series.Count, series.Sum, series.Bounds, series.Buckets = 0, 0, point.Bounds, make([]uint64, len(point.Buckets))
+		}
+		series.Count += point.Count
+		series.Sum += point.Sum
+		for i, c := range point.Buckets {
+			series.Buckets[i] += c
+		}
+	case otlpSummary:
+		series.Count, series.Sum, series.Quantiles = point.Count, point.Sum, point.Quantiles
+	}
+	return true
+}
+
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *otlpReceiver) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.flush()
+	}
+}
+
+// flush pushes the latest metrics of every POD, and forgets the PODs which have been deleted or have no live series.
+func (r *otlpReceiver) flush() {
+	pushes := map[*corev1.Pod][]*dto.MetricFamily{}
+	gone := []*corev1.Pod{}
+	r.lock.Lock()
+	for uid, state := range r.pods {
+		if !podExists(state.Pod) || !state.expire() {
+			gone = append(gone, state.Pod)
+			delete(r.pods, uid)
+			continue
+		}
+		pushes[state.Pod] = state.build()
+	}
+	r.lock.Unlock()
+	for _, pod := range gone {
+		log.Debugf("Removing OTLP metrics of POD: %s", pod.Name)
+		deleteReceivedMetrics(pod, otlpSource)
+	}
+	for pod, families := range pushes {
+		if err := pushReceivedMetrics(pod, otlpSource, families); err != nil {
+			log.Errorf("Failed to encode OTLP metrics, POD: %s, error: %s", pod.Name, err.Error())
+		}
+	}
+}
+
+// expire drops the series which have NOT been updated for otlpSeriesIdleFlushes flushes, and ages the others.
+// false will be returned if no series is left.
+func (state *otlpPodState) expire() bool {
+	for key, s := range state.Series {
+		if s.Idle >= otlpSeriesIdleFlushes {
+			delete(state.Series, key)
+			continue
+		}
+		s.Idle++
+	}
+	state.Types = make(map[string]dto.MetricType, len(state.Types))
+	for _, s := range state.Series {
+		state.Types[s.Name] = s.Type
+	}
+	return len(state.Series) > 0
+}
+
+func (state *otlpPodState) build() []*dto.MetricFamily {
+	keys := make([]string, 0, len(state.Series))
+	for key := range state.Series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	byName := map[string]*dto.MetricFamily{}
+	families := []*dto.MetricFamily{}
+	for _, key := range keys {
+		s := state.Series[key]
+		family, ok := byName[s.Name]
+		if !ok {
+			name, help := s.Name, s.Help
+			family = &dto.MetricFamily{Name: &name, Type: s.Type.Enum()}
+			if help != "" {
+				family.Help = &help
+			}
+			byName[name] = family
+			families = append(families, family)
+		}
+		family.Metric = append(family.Metric, s.build())
+	}
+	sortMetricFamilies(families)
+	return families
+}
+
+func (s *otlpSeries) build() *dto.Metric {
+	metric := &dto.Metric{}
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		name, value := name, s.Labels[name]
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	value, count, sum := s.Value, s.Count, s.Sum
+	switch s.Type {
+	case dto.MetricType_COUNTER:
+		metric.Counter = &dto.Counter{Value: &value}
+	case dto.MetricType_HISTOGRAM:
+		metric.Histogram = &dto.Histogram{SampleCount: &count, SampleSum: &sum}
+		//the OTLP bucket counts are NOT cumulative, and the last one is the implicit "+Inf" bucket.
+		cumulative := uint64(0)
+		for i, bound := range s.Bounds {
+			cumulative += s.Buckets[i]
+			bound, c := bound, cumulative
+			metric.Histogram.Bucket = append(metric.Histogram.Bucket, &dto.Bucket{UpperBound: &bound, CumulativeCount: &c})
+		}
+	case dto.MetricType_SUMMARY:
+		metric.Summary = &dto.Summary{SampleCount: &count, SampleSum: &sum}
+		for _, q := range s.Quantiles {
+			q := q
+			metric.Summary.Quantile = append(metric.Summary.Quantile, &dto.Quantile{Quantile: &q.Quantile, Value: &q.Value})
+		}
+	default:
+		metric.Gauge = &dto.Gauge{Value: &value}
+	}
+	return metric
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newOTLPTestState() *otlpPodState {
+	if args == nil {
+		args = &CommandLineArgs{}
+	}
+	return &otlpPodState{Series: map[string]*otlpSeries{}, Types: map[string]dto.MetricType{}}
+}
+
+func TestOTLPAggregate(t *testing.T) {
+	state := newOTLPTestState()
+	deltaSum := &otlpMetric{Name: "requests", Kind: otlpSum, Monotonic: true, Temporality: otlpTemporalityDelta}
+	cumulativeSum := &otlpMetric{Name: "connections", Kind: otlpSum, Temporality: otlpTemporalityCumulative}
+	deltaHistogram := &otlpMetric{Name: "latency", Unit: "ms", Kind: otlpHistogram, Temporality: otlpTemporalityDelta}
+	cumulativeHistogram := &otlpMetric{Name: "size", Unit: "By", Kind: otlpHistogram, Temporality: otlpTemporalityCumulative}
+	points := []struct {
+		metric *otlpMetric
+		point  *otlpPoint
+	}{
+		{deltaSum, &otlpPoint{Attributes: map[string]string{"method": "GET"}, Value: 3}},
+		{deltaSum, &otlpPoint{Attributes: map[string]string{"method": "GET"}, Value: 2}},
+		{deltaSum, &otlpPoint{Attributes: map[string]string{"method": "PUT", "instance": "other"}, Value: 1}},
+		{cumulativeSum, &otlpPoint{Value: 3}},
+		{cumulativeSum, &otlpPoint{Value: 2}},
+		{deltaHistogram, &otlpPoint{Count: 4, Sum: 10, Bounds: []float64{1, 5}, Buckets: []uint64{1, 2, 1}}},
+		{deltaHistogram, &otlpPoint{Count: 2, Sum: 3, Bounds: []float64{1, 5}, Buckets: []uint64{1, 1, 0}}},
+		{cumulativeHistogram, &otlpPoint{Count: 5, Sum: 50, Bounds: []float64{10}, Buckets: []uint64{4, 1}}},
+		{cumulativeHistogram, &otlpPoint{Count: 6, Sum: 70, Bounds: []float64{10}, Buckets: []uint64{4, 2}}},
+	}
+	for i, p := range points {
+		if !state.aggregate(p.metric, p.point) {
+			t.Fatalf("data point %d is dropped", i)
+		}
+	}
+	if state.aggregate(&otlpMetric{Name: "latency", Unit: "ms", Kind: otlpGauge}, &otlpPoint{Value: 1}) {
+		t.Errorf("data point of a conflicting type is accepted")
+	}
+	want := `# TYPE connections gauge
+connections 2
+# TYPE latency_milliseconds histogram
+latency_milliseconds_bucket{le="1"} 2
+latency_milliseconds_bucket{le="5"} 5
+latency_milliseconds_bucket{le="+Inf"} 6
+latency_milliseconds_sum 13
+latency_milliseconds_count 6
+# TYPE requests_total counter
+requests_total{method="GET"} 5
+requests_total{method="PUT"} 1
+# TYPE size_bytes histogram
+size_bytes_bucket{le="10"} 4
+size_bytes_bucket{le="+Inf"} 6
+size_bytes_sum 70
+size_bytes_count 6
+`
+	if got := encodeForTest(t, state.build()); got != want {
+		t.Errorf("unexpected metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestOTLPHistogramBoundsChanged(t *testing.T) {
+	state := newOTLPTestState()
+	metric := &otlpMetric{Name: "latency", Kind: otlpHistogram, Temporality: otlpTemporalityDelta}
+	state.aggregate(metric, &otlpPoint{Count: 4, Sum: 10, Bounds: []float64{1, 5}, Buckets: []uint64{1, 2, 1}})
+	state.aggregate(metric, &otlpPoint{Count: 1, Sum: 2, Bounds: []float64{2}, Buckets: []uint64{0, 1}})
+	state.aggregate(metric, &otlpPoint{Count: 2, Sum: 3, Bounds: []float64{2}, Buckets: []uint64{1, 1}})
+	want := `# TYPE latency histogram
+latency_bucket{le="2"} 1
+latency_bucket{le="+Inf"} 3
+latency_sum 5
+latency_count 3
+`
+	if got := encodeForTest(t, state.build()); got != want {
+		t.Errorf("unexpected metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestOTLPExpire(t *testing.T) {
+	state := newOTLPTestState()
+	live := &otlpMetric{Name: "live", Kind: otlpGauge}
+	stale := &otlpMetric{Name: "stale", Kind: otlpSum, Monotonic: true, Temporality: otlpTemporalityCumulative}
+	state.aggregate(live, &otlpPoint{Value: 1})
+	state.aggregate(stale, &otlpPoint{Value: 1})
+	for i := 0; i < otlpSeriesIdleFlushes; i++ {
+		if !state.expire() || len(state.Series) != 2 {
+			t.Fatalf("series expired after %d flushes", i+1)
+		}
+		state.aggregate(live, &otlpPoint{Value: float64(i)})
+	}
+	if !state.expire() || len(state.Series) != 1 {
+		t.Fatalf("stale series is NOT expired, series: %d", len(state.Series))
+	}
+	if _, ok := state.Types["stale_total"]; ok {
+		t.Errorf("type of the expired series is kept")
+	}
+	for i := 0; i < otlpSeriesIdleFlushes; i++ {
+		state.expire()
+	}
+	if state.expire() {
+		t.Errorf("state is NOT empty after all series expired")
+	}
+}
+
+func TestOTLPPushReplacesGroup(t *testing.T) {
+	newOTLPTestState()
+	saved := prometheusOutputChan
+	prometheusOutputChan = make(chan *PrometheusData, 1)
+	t.Cleanup(func() { prometheusOutputChan = saved })
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "default", UID: "uid"}}
+	name := "up"
+	families := []*dto.MetricFamily{{Name: &name, Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: new(float64)}}}}}
+	if err := pushReceivedMetrics(pod, otlpSource, families); err != nil {
+		t.Fatal(err)
+	}
+	//the expired series are ONLY removed from the push gateway if the whole group is replaced.
+	req, err := newPushRequest(&pushDestination{URL: "http://gateway"}, <-prometheusOutputChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "PUT" || !strings.HasSuffix(req.URL.Path, "/instance/app-0/source/otlp") {
+		t.Errorf("unexpected push request: %s %s", req.Method, req.URL.Path)
+	}
+}
@@ -1,14 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/expfmt"
+	dto "github.com/prometheus/client_model/go"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"net/http"
@@ -37,80 +35,260 @@ type PrometheusData struct {
 	PodIP        string
 	HostIP       string
 	Namespace    string
+	PodUID       types.UID
+	Container    string //labelled container of the per-container endpoints.
+	HostNetwork  bool
+	Source       string //empty for the POD's own metrics, "kubelet" for the container metrics from the kubelet.
+	SourceGroup  string //extra "source" label of the grouping key, which keeps the received metrics apart from the scraped ones.
+	PodLabels    map[string]string
+	Tenant       string
 	NeedDelete   bool
+	Replace      bool //pushed with PUT which replaces the whole group, the metrics missing from the push are removed.
 }
 
 type PODMetricsMonitor struct {
-	Event  PODEvent
-	Ctx    context.Context //used for cancellation.
-	Cancel func()
-	client *http.Client
+	Event      PODEvent
+	Ctx        context.Context //used for cancellation.
+	Cancel     func()
+	done       chan struct{} //closed once the fetching goroutine has exited, ONLY accessed with the global lock held.
+	stateLock  sync.Mutex
+	readySince map[string]time.Time //keyed by the target's name, absent if NOT ready.
+	lastPushed map[string]*PrometheusData
+	finalizing bool //ONLY accessed with the global lock held.
 }
 
+// scrapeClient is owned by a single fetching goroutine, so that a restarted monitor never shares it with the stopped one.
+type scrapeClient struct {
+	client             *http.Client
+	timeout            time.Duration
+	credentials        *scrapeCredentials
+	credentialsVersion string
+}
+
+// Start begins fetching with the durations which have been validated by ParseAnnotation.
 func (m *PODMetricsMonitor) Start() {
-	if m.client == nil {
-		duration, err := time.ParseDuration(m.Event.FechingTimeout)
-		if err != nil {
-			log.Panicf("Failed to parse formatted timeout string for fetching the remote metrics.")
-		}
-		m.client = &http.Client{Timeout: duration, Transport: &http.Transport{MaxIdleConns: 10, TLSHandshakeTimeout: 0}}
-	}
+	ctx := m.Ctx
+	c := &scrapeClient{timeout: m.Event.Timeout}
+	previous := m.done
+	done := make(chan struct{})
+	m.done = done
 	go func() {
-		duration, err := time.ParseDuration(m.Event.FechingInterval)
-		if err != nil {
-			log.Panicf("Failed to parse formatted duration string: %s", m.Event.FechingInterval)
+		defer close(done)
+		//the cancelled goroutine of a restarted monitor may still be fetching.
+		if previous != nil {
+			<-previous
 		}
-		timeChan := time.Tick(duration)
+		ticker := time.NewTicker(m.Event.Interval)
+		defer ticker.Stop()
 		for {
 			select {
-			case <-m.Ctx.Done():
-				m.client = nil
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				doFetch(m, c)
+			}
+		}
+	}()
+}
+
+// Restart stops the running fetching goroutine and starts a new one with the given event.
+func (m *PODMetricsMonitor) Restart(e *PODEvent) {
+	m.Cancel()
+	releaseHostNetworkTargets(m.Event.Pod.UID)
+	//the Event is read by the fetching goroutines.
+	m.stateLock.Lock()
+	m.Event = *e
+	m.stateLock.Unlock()
+	m.Ctx, m.Cancel = context.WithCancel(context.Background())
+	m.Start()
+}
+
+// snapshot returns a copy of the monitored event which refers to the latest POD in the informer cache,
+// so that the changes of IP, phase, labels and owners are picked up immediately.
+func (m *PODMetricsMonitor) snapshot() PODEvent {
+	m.stateLock.Lock()
+	e := m.Event
+	m.stateLock.Unlock()
+	e.Pod = livePod(e.Pod)
+	return e
+}
+
+// observe tracks the readiness of every target (the container which owns the port, or the POD).
+func (m *PODMetricsMonitor) observe(pod *corev1.Pod) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.readySince == nil {
+		m.readySince = make(map[string]time.Time)
+	}
+	for _, t := range m.Event.Targets {
+		_, wasReady := m.readySince[t.Name]
+		if !isPodReady(pod, t.Container) {
+			if wasReady {
+				log.Infof("Paused fetching metrics of POD: %s (%s) since it became NOT ready.", pod.Name, t.String())
+			}
+			delete(m.readySince, t.Name)
+		} else if !wasReady {
+			m.readySince[t.Name] = time.Now()
+		}
+	}
+}
+
+// scrapable returns true once the target has been ready for the warm-up delay.
+func (m *PODMetricsMonitor) scrapable(t scrapeTarget) bool {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	since, ok := m.readySince[t.Name]
+	return ok && time.Since(since) >= m.Event.WarmUp
+}
+
+// finalize stops the periodic fetching, pushes the last fetched metrics if required, forgets the monitor,
+// and removes the metrics after the delete delay. The global lock must be held.
+func (m *PODMetricsMonitor) finalize(e *PODEvent, finalScrape bool) {
+	m.finalizing = true
+	m.Cancel()
+	done := m.done
+	go func() {
+		//never fetch concurrently with the periodic fetching.
+		<-done
+		if finalScrape {
+			doFetch(m, &scrapeClient{timeout: e.Timeout})
+		}
+		releaseHostNetworkTargets(e.Pod.UID)
+		//the last metrics have been pushed, the finished POD is never monitored again even if it's NOT deleted yet.
+		lock.Lock()
+		if monitoringPods[e.Pod.UID] == m {
+			delete(monitoringPods, e.Pod.UID)
+		}
+		lock.Unlock()
+		if m.Event.DeleteDelay > 0 {
+			time.Sleep(m.Event.DeleteDelay)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		//a POD with the same name (e.g. of a StatefulSet) has taken over the metrics.
+		for uid, other := range monitoringPods {
+			if uid != e.Pod.UID && !other.finalizing && other.Event.Pod.Namespace == e.Pod.Namespace && other.Event.Pod.Name == e.Pod.Name {
 				return
-			case <-timeChan:
-				doFetch(m)
 			}
 		}
+		//try removing remote persisted Prometheus metrics.
+		deleteMessages(e, m.Event.Targets)
 	}()
 }
 
-func doFetch(m *PODMetricsMonitor) {
-	url := fmt.Sprintf("http://%s%s", m.Event.Pod.Status.PodIP, m.Event.Endpoints)
-	log.Debugf("%#v", m.Event.Pod.Status)
-	log.Debugf("Preparing to fetch metrics URL: %s, POD IP: %s", url, m.Event.Pod.Status.PodIP)
-	//m.Event.Endpoints support ONLY one address by now.
-	req, err := http.NewRequest("GET", url, nil)
+// prepare (re)builds the HTTP client whenever the Secret referenced by the event has been changed.
+func (c *scrapeClient) prepare(e *PODEvent) error {
+	creds, err := loadScrapeCredentials(e.Pod.Namespace, e.AuthSecret)
 	if err != nil {
-		fetchFailedCounter.Inc()
-		log.Errorf("[Fetching Metric] Failed to fetch POD's metric, error: %s", err.Error())
-		return
+		return err
+	}
+	version := ""
+	if creds != nil {
+		version = creds.ResourceVersion
 	}
-	rsp, err := m.client.Do(req)
+	if c.client != nil && c.credentialsVersion == version {
+		return nil
+	}
+	transport, err := newScrapeTransport(e, creds)
 	if err != nil {
-		fetchFailedCounter.Inc()
-		log.Errorf("[Fetching Metric] Failed to fetch POD's metric, error: %s", err.Error())
-		return
+		return err
 	}
-	defer rsp.Body.Close()
-	if rsp.StatusCode != http.StatusOK {
-		fetchFailedCounter.Inc()
-		log.Errorf("[Fetching Metric] Failed to fetch POD's metric, HTTP response status code: %d", rsp.StatusCode)
+	c.client = &http.Client{Timeout: c.timeout, Transport: transport}
+	c.credentials = creds
+	c.credentialsVersion = version
+	return nil
+}
+
+func doFetch(m *PODMetricsMonitor, c *scrapeClient) {
+	e := m.snapshot()
+	host := scrapeHost(&e)
+	if host == "" {
+		log.Debugf("Skipped fetching metrics of POD: %s without any IP.", e.Pod.Name)
 		return
 	}
-	data, err := ioutil.ReadAll(rsp.Body)
-	if err != nil {
-		fetchFailedCounter.Inc()
-		log.Errorf("[Fetching Metric] Failed to read HTTP response body: %s", err.Error())
+	var all []*dto.MetricFamily
+	var lastErr error
+	complete := true
+	for _, t := range e.Targets {
+		if !m.scrapable(t) {
+			log.Debugf("Skipped fetching metrics of POD: %s (%s) since it is NOT ready or still warming up.", e.Pod.Name, t.String())
+			complete = false
+			continue
+		}
+		if e.Pod.Spec.HostNetwork {
+			if owner, ok := claimHostNetworkTarget(t.URL(e.Scheme, host), e.Pod.UID); !ok {
+				log.Debugf("Skipped fetching metrics of hostNetwork POD: %s (%s) since the same address is scraped for POD: %s.", e.Pod.Name, t.String(), owner)
+				complete = false
+				continue
+			}
+		}
+		families, err := fetchMetrics(c, &e, t)
+		if raw, ok := err.(*unparsedMetricsError); ok && e.MetricType == "prometheus" {
+			//pushed as-is like the bridge always did, the metrics info can't be tagged without parsing.
+			fetchSucceedCounter.Inc()
+			complete = false
+			log.Warnf("[Fetching Metric] Pushing unparsed metrics of POD: %s (%s), error: %s", e.Pod.Name, t.String(), raw.Error())
+			if data := sendRawMessage(&e, t, raw.Data); data != nil {
+				m.rememberPushed(t, data)
+			}
+			continue
+		}
+		if err != nil {
+			fetchFailedCounter.Inc()
+			if le, ok := err.(*limitExceededError); ok {
+				fetchLimitExceededCounter.WithLabelValues(le.Limit).Inc()
+			}
+			lastErr = err
+			complete = false
+			log.Errorf("[Fetching Metric] Failed to fetch metrics of POD: %s (%s), error: %s", e.Pod.Name, t.String(), err.Error())
+			recorder.Warningf(podReference(e.Pod), eventReasonScrapeFailed, "Failed to scrape metrics from %s: %s", t.String(), err.Error())
+			continue
+		}
+		fetchSucceedCounter.Inc()
+		all = append(all, families...)
+		if data := sendMessage(&e, t, families, false); data != nil {
+			m.rememberPushed(t, data)
+		}
+	}
+	if len(all) == 0 && lastErr == nil {
+		//nothing has been fetched.
 		return
 	}
-	fetchSucceedCounter.Inc()
-	sendMessage(&m.Event, data, false)
+	reportScrapeResult(e.Pod.UID, lastErr)
+	//the metrics info can ONLY be tagged once all targets have been fetched.
+	if complete {
+		sortMetricFamilies(all)
+		if needUpdateAnnotation(&e, all) {
+			updatePod(&e)
+		}
+	}
+}
+
+// rememberPushed removes the metrics pushed to the previous grouping key if the owner of the POD has been changed.
+func (m *PODMetricsMonitor) rememberPushed(t scrapeTarget, data *PrometheusData) {
+	m.stateLock.Lock()
+	if m.lastPushed == nil {
+		m.lastPushed = make(map[string]*PrometheusData)
+	}
+	last := m.lastPushed[t.Name]
+	m.lastPushed[t.Name] = data
+	m.stateLock.Unlock()
+	if last != nil && groupingPath(last) != groupingPath(data) {
+		stale := *last
+		stale.RspData = nil
+		stale.NeedDelete = true
+		prometheusOutputChan <- &stale
+	}
 }
 
 func initKubernetesPODEventProcessor(eventChan chan *PODEvent) chan *PrometheusData {
 	log.Infoln("Initializing Kubernetes POD's event processor...")
 	prometheus.MustRegister(fetchSucceedCounter)
 	prometheus.MustRegister(fetchFailedCounter)
+	prometheus.MustRegister(fetchLimitExceededCounter)
+	prometheus.MustRegister(targetConfigErrorGauge)
 	http.Handle("/metrics", prometheus.Handler())
+	registerAdminAPI()
 	go func() {
 		log.Fatal(http.ListenAndServe(":36000", nil))
 	}()
@@ -130,43 +308,63 @@ func readPodEvents(eventChan chan *PODEvent) {
 }
 
 func processPodEvent(e *PODEvent) {
-	if e.Status == POD_ADD && !e.HasAnnotation {
+	if e.Status == POD_DELETE || !e.HasAnnotation {
+		forgetTargetStatus(e.Pod)
+	} else {
+		recordTargetStatus(e)
+	}
+	if e.Status == POD_ADD && !e.monitorable() {
 		return
 	}
 	lock.Lock()
 	defer lock.Unlock()
 	if monitor, ok := monitoringPods[e.Pod.UID]; ok {
+		if monitor.finalizing {
+			if e.Status == POD_DELETE {
+				delete(monitoringPods, e.Pod.UID)
+			}
+			return
+		}
 		if e.Status == POD_DELETE {
 			delete(monitoringPods, e.Pod.UID)
-			monitor.Cancel()
-			//try removing remote persisted Prometheus metrics.
-			sendMessage(e, nil, true)
+			monitor.finalize(e, false)
 		} else if e.Status == POD_UPDATE {
-			//never exposed any metric endpoints, close it.
-			if !e.HasAnnotation {
+			//never exposed any metric endpoints or the annotations became invalid, close it.
+			if !e.monitorable() {
 				delete(monitoringPods, e.Pod.UID)
 				monitor.Cancel()
+				releaseHostNetworkTargets(e.Pod.UID)
 				//try removing remote persisted Prometheus metrics.
-				sendMessage(e, nil, true)
+				deleteMessages(e, monitor.Event.Targets)
+				return
+			}
+			//all containers have exited, nothing can be fetched any more.
+			if isPodFinished(e.Pod) {
+				monitor.finalize(e, false)
+				return
+			}
+			//push the last metrics before the POD has gone.
+			if isPodTerminating(e.Pod) {
+				monitor.finalize(e, true)
 				return
 			}
 			//annotation updated, try restarting it.
 			if isAnnotationChanged(&monitor.Event, e) {
-				monitor.Cancel()
-				monitor.Event = *e
-				monitor.Start()
-				return
+				monitor.Restart(e)
 			}
+			monitor.observe(e.Pod)
 		}
 	} else {
-		if e.Status == POD_ADD || (e.Status == POD_UPDATE && e.HasAnnotation) {
-			if e.Pod.Status.PodIP == "" {
-				log.Debugf("Ignored POD \"%s\" without any IP.", e.Pod.Name)
+		if e.Status == POD_ADD || (e.Status == POD_UPDATE && e.monitorable()) {
+			//the POD without any IP will be monitored, but NOT fetched until it becomes ready.
+			if isPodTerminating(e.Pod) || isPodFinished(e.Pod) {
+				log.Debugf("Ignored terminating or finished POD \"%s\".", e.Pod.Name)
 				return
 			}
 			ctx, cancel := context.WithCancel(context.Background())
 			pmm := &PODMetricsMonitor{Event: *e, Ctx: ctx, Cancel: cancel}
 			monitoringPods[e.Pod.UID] = pmm
+			pmm.observe(e.Pod)
 			pmm.Start()
 		}
 	}
@@ -175,7 +373,7 @@ func isAnnotationChanged(old *PODEvent, new *PODEvent) bool {
 	if old.MetricType != new.MetricType {
 		return true
 	}
-	if old.Endpoints != new.Endpoints {
+	if old.Endpoints != new.Endpoints || !sameTargets(old.Targets, new.Targets) {
 		return true
 	}
 	if old.FechingInterval != new.FechingInterval {
@@ -187,15 +385,79 @@ func isAnnotationChanged(old *PODEvent, new *PODEvent) bool {
 	if old.LabeledNamespace != new.LabeledNamespace {
 		return true
 	}
+	if old.HostNetworkAddress != new.HostNetworkAddress {
+		return true
+	}
+	if old.Scheme != new.Scheme || old.TLSInsecure != new.TLSInsecure || old.ServerName != new.ServerName {
+		return true
+	}
+	if old.Config != new.Config || old.ConfigMap != new.ConfigMap {
+		return true
+	}
+	if old.AuthSecret != new.AuthSecret {
+		return true
+	}
+	if old.Tenant != new.Tenant {
+		return true
+	}
+	if old.WarmUp != new.WarmUp || old.DeleteDelay != new.DeleteDelay {
+		return true
+	}
+	if old.Limits != new.Limits {
+		return true
+	}
 	return false
 }
 
-func sendMessage(e *PODEvent, data []byte, needDelete bool) {
+// sendMessage sends the metrics of the target to the pusher and returns the sent data, or nil if failed.
+func sendMessage(e *PODEvent, t scrapeTarget, families []*dto.MetricFamily, needDelete bool) *PrometheusData {
+	obj, err := buildPrometheusData(e, t, families, needDelete)
+	if err != nil {
+		log.Errorf("Failed to encode Prometheus metrics, POD: %s, error: %s", e.Pod.Name, err.Error())
+		return nil
+	}
+	prometheusOutputChan <- obj
+	return obj
+}
+
+// sendRawMessage pushes the body of the target which can't be parsed without any conversion.
+func sendRawMessage(e *PODEvent, t scrapeTarget, body []byte) *PrometheusData {
+	obj, err := buildPrometheusData(e, t, nil, false)
+	if err != nil {
+		log.Errorf("Failed to encode Prometheus metrics, POD: %s, error: %s", e.Pod.Name, err.Error())
+		return nil
+	}
+	obj.RspData = body
+	prometheusOutputChan <- obj
+	return obj
+}
+
+// deleteMessages removes the pushed metrics of the targets and the tagged metrics info of the POD.
+func deleteMessages(e *PODEvent, targets []scrapeTarget) {
+	if needUpdateAnnotation(e, nil) {
+		updatePod(e)
+	}
+	podLevel := false
+	for _, t := range targets {
+		sendMessage(e, t, nil, true)
+		podLevel = podLevel || t.Name == ""
+	}
+	//the kubelet metrics are always pushed under the POD level grouping key.
+	if nodeCollector != nil && !podLevel {
+		sendMessage(e, scrapeTarget{}, nil, true)
+	}
+}
+
+func buildPrometheusData(e *PODEvent, t scrapeTarget, families []*dto.MetricFamily, needDelete bool) (*PrometheusData, error) {
 	kind, name, ns, err := retrievePodInformation(e.Pod)
 	if err != nil {
 		log.Errorf("Failed to retrieve POD's resource metadata (%s), error: %s", e.Pod.Name, err.Error())
 	}
-	obj := &PrometheusData{
+	data, err := encodeMetricFamilies(families)
+	if err != nil {
+		return nil, err
+	}
+	return &PrometheusData{
 		RspData:      data,
 		FetchingTime: time.Now(),
 		ResourceName: fmt.Sprintf("%s_%s_%s", ns, kind, name),
@@ -203,38 +465,38 @@ func sendMessage(e *PODEvent, data []byte, needDelete bool) {
 		PodIP:        e.Pod.Status.PodIP,
 		HostIP:       e.Pod.Status.HostIP,
 		Namespace:    e.Pod.Namespace,
-		NeedDelete:   needDelete}
-	needUpdate, err := needUpdateAnnotation(e, obj)
-	if err != nil {
-		log.Errorf("Failed to parse Prometheus metric metadata, POD: %s, error: %s", e.Pod.Name, err.Error())
-	}
-	if needUpdate {
-		updatePod(e)
-	}
-	prometheusOutputChan <- obj
+		PodUID:       e.Pod.UID,
+		Container:    t.Name,
+		HostNetwork:  e.Pod.Spec.HostNetwork,
+		PodLabels:    e.Pod.Labels,
+		Tenant:       e.Tenant,
+		NeedDelete:   needDelete}, nil
 }
 
-func needUpdateAnnotation(e *PODEvent, obj *PrometheusData) (bool, error) {
-	var err error
-	parser := expfmt.TextParser{}
-	metrics, err := parser.TextToMetricFamilies(bytes.NewReader(obj.RspData))
-	if err != nil {
-		return false, err
+func needUpdateAnnotation(e *PODEvent, families []*dto.MetricFamily) bool {
+	oldAnnotatedStr := e.Pod.Annotations[automaticTaggedAnnotationKey]
+	newAnnotatedStr := buildMetricsInfoAnnotation(families)
+	if oldAnnotatedStr != newAnnotatedStr {
+		e.NeededAppendingAnnotation = newAnnotatedStr
+		return true
 	}
+	return false
+}
+
+// buildMetricsInfoAnnotation builds the value of "io.auto-tagged.metrics-info" annotation.
+func buildMetricsInfoAnnotation(families []*dto.MetricFamily) string {
 	sb := strings.Builder{}
-	for _, v := range metrics {
+	for i, v := range families {
+		//the same family may be exposed by multiple containers.
+		if i > 0 && v.GetName() == families[i-1].GetName() {
+			continue
+		}
 		sb.WriteString(*v.Name)
 		sb.WriteString(",")
 		sb.WriteString(v.Type.String())
 		sb.WriteString(";")
 	}
-	oldAnnotatedStr := e.Pod.Annotations[automaticTaggedAnnotationKey]
-	newAnnotatedStr := sb.String()
-	if oldAnnotatedStr != newAnnotatedStr {
-		e.NeededAppendingAnnotation = newAnnotatedStr
-		return true, nil
-	}
-	return false, nil
+	return sb.String()
 }
 
 type Annotation struct {
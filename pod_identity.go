@@ -0,0 +1,133 @@
+package main
+
+import (
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	"net"
+)
+
+const (
+	//names of the indices which look up the PODs on current node by their IPs & UIDs.
+	podIPIndex  = "podIP"
+	podUIDIndex = "podUID"
+)
+
+// indexPodByIP indexes the PODs by their own IPs, the hostNetwork PODs are skipped since they share the node's IP.
+func indexPodByIP(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork || pod.Status.PodIP == "" {
+		return nil, nil
+	}
+	return []string{pod.Status.PodIP}, nil
+}
+
+func indexPodByUID(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	return []string{string(pod.UID)}, nil
+}
+
+// podByUID returns the POD on current node with given UID, nil if not found.
+func podByUID(uid string) *corev1.Pod {
+	if podIndexer == nil {
+		return nil
+	}
+	objs, err := podIndexer.ByIndex(podUIDIndex, uid)
+	if err != nil || len(objs) == 0 {
+		return nil
+	}
+	return objs[0].(*corev1.Pod)
+}
+
+// podByIP identifies the POD which sent the data from given remote address (with or without port),
+// nil will be returned if no running POD or more than one POD owns the IP.
+func podByIP(address string) *corev1.Pod {
+	if podIndexer == nil {
+		return nil
+	}
+	ip := address
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		ip = host
+	}
+	objs, err := podIndexer.ByIndex(podIPIndex, ip)
+	if err != nil {
+		return nil
+	}
+	var found *corev1.Pod
+	for _, obj := range objs {
+		pod := obj.(*corev1.Pod)
+		//the IPs of the finished PODs may have been reused.
+		if isPodFinished(pod) {
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = pod
+	}
+	return found
+}
+
+// isNodeAddress returns true if the remote address (with or without port) is the node's IP or loopback,
+// i.e. the sender is running with hostNetwork on current node.
+func isNodeAddress(address, hostIP string) bool {
+	ip := address
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		ip = host
+	}
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.IsLoopback() {
+		return true
+	}
+	return hostIP != "" && ip == hostIP
+}
+
+// podExists returns true if the POD is still cached by the informer.
+func podExists(pod *corev1.Pod) bool {
+	if podIndexer == nil {
+		return false
+	}
+	obj, exists, err := podIndexer.GetByKey(pod.Namespace + "/" + pod.Name)
+	if err != nil || !exists {
+		return false
+	}
+	return obj.(*corev1.Pod).UID == pod.UID
+}
+
+// newReceiverEvent builds the event of a POD whose metrics are received rather than fetched,
+// ONLY the annotations which affect the naming & routing are honored.
+func newReceiverEvent(pod *corev1.Pod) *PODEvent {
+	e := &PODEvent{Pod: pod}
+	e.LabeledNamespace = e.annotationOrDefault("namespace", args.LabeledNamespace)
+	e.Tenant, _ = e.annotation("tenant")
+	return e
+}
+
+// pushReceivedMetrics sends the metrics received from the POD to the push path under the POD's grouping key with an extra
+// "source" label, so that they never overwrite the scraped ones, and can be deleted without touching them. The receiver
+// owns the whole group, so it's replaced by every push and the expired series are removed as well.
+func pushReceivedMetrics(pod *corev1.Pod, source string, families []*dto.MetricFamily) error {
+	e := newReceiverEvent(pod)
+	applyMetricPrefix(families, e.LabeledNamespace)
+	data, err := buildPrometheusData(e, scrapeTarget{}, families, false)
+	if err != nil {
+		return err
+	}
+	data.Source = source
+	data.SourceGroup = source
+	data.Replace = true
+	prometheusOutputChan <- data
+	return nil
+}
+
+// deleteReceivedMetrics removes the grouping key of the source of a POD which has gone.
+func deleteReceivedMetrics(pod *corev1.Pod, source string) {
+	data, err := buildPrometheusData(newReceiverEvent(pod), scrapeTarget{}, nil, true)
+	if err != nil {
+		return
+	}
+	data.Source = source
+	data.SourceGroup = source
+	prometheusOutputChan <- data
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// isPodReady returns true if the container which owns the scraped port is ready,
+// the POD's Ready condition will be used if the owner is unknown.
+func isPodReady(pod *corev1.Pod, container string) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	if name := container; name != "" {
+		for _, s := range pod.Status.ContainerStatuses {
+			if s.Name == name {
+				return s.Ready
+			}
+		}
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func containerOwningPort(pod *corev1.Pod, port int) string {
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if int(p.ContainerPort) == port {
+				return c.Name
+			}
+		}
+	}
+	return ""
+}
+
+// isPodFinished returns true if all containers of the POD have terminated and will not be restarted.
+func isPodFinished(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// isPodTerminating returns true once the POD has been requested to be deleted.
+func isPodTerminating(pod *corev1.Pod) bool {
+	return pod.DeletionTimestamp != nil
+}
@@ -10,7 +10,6 @@ import (
 )
 
 var (
-	pushGWClient       *http.Client
 	pushSucceedCounter = prometheus.NewCounter(prometheus.CounterOpts{Name: "push_prometheus_metrics_succeed_count_total", Help: "Total count of successfully push the remote Prometheus metric endpoints."})
 	pushFailedCounter  = prometheus.NewCounter(prometheus.CounterOpts{Name: "push_prometheus_metrics_failed_count_total", Help: "Total count of failed pushing the remote Prometheus metric endpoints."})
 )
@@ -19,26 +18,79 @@ func initializePrometheusPusher(data chan *PrometheusData) {
 	log.Infoln("Initializing Prometheus push GW proxy...")
 	prometheus.MustRegister(pushSucceedCounter)
 	prometheus.MustRegister(pushFailedCounter)
+	prometheus.MustRegister(pushDroppedCounter)
+	prometheus.MustRegister(pushSkippedCounter)
+	var err error
+	if router, err = newPushRouterFromArgs(); err != nil {
+		log.Panicf("Failed to initialize Prometheus push GW, err: %s", err.Error())
+	}
+	if args.PushDeduplication {
+		pushCache = newPushDeduplicator(args.PushDeduplicationMaxSkips)
+	}
+	go readMessage(data)
+}
+
+func newPushRouterFromArgs() (*pushRouter, error) {
 	duration, err := time.ParseDuration(args.RemotePrometheusPushGWAddrHttpTimeout)
 	if err != nil {
-		log.Panicf("Failed to parse GW push timeout value to type of time.duration, err: %s", err.Error())
+		return nil, fmt.Errorf("Failed to parse GW push timeout value to type of time.duration, err: %s", err.Error())
 	}
-	pushGWClient = &http.Client{
-		Timeout:   duration,
-		Transport: &http.Transport{MaxIdleConns: 10, TLSHandshakeTimeout: 0}}
-	go readMessage(data)
+	var defaultDestination *pushDestination
+	if args.RemotePrometheusPushGWAddr != "" {
+		defaultDestination, err = newPushDestination(&pushDestinationConfig{
+			Name:         defaultDestinationName,
+			Address:      args.RemotePrometheusPushGWAddr,
+			Timeout:      duration,
+			CAFile:       args.RemotePrometheusPushGWCAFile,
+			CertFile:     args.RemotePrometheusPushGWCertFile,
+			KeyFile:      args.RemotePrometheusPushGWKeyFile,
+			Insecure:     args.RemotePrometheusPushGWInsecure,
+			Username:     args.RemotePrometheusPushGWUsername,
+			PasswordFile: args.RemotePrometheusPushGWPasswordFile,
+			TokenFile:    args.RemotePrometheusPushGWTokenFile,
+			Headers:      args.RemotePrometheusPushGWHeaders})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newPushRouter(defaultDestination, args.RoutingFile, duration)
 }
 
 func readMessage(data chan *PrometheusData) {
 	var err error
 	for msg := range data {
+		dest := router.route(msg)
+		if dest == nil {
+			pushDroppedCounter.Inc()
+			log.Debugf("Dropped metrics for POD: %s since no push gateway matched.", msg.PodName)
+			continue
+		}
 		if !msg.NeedDelete { //PUSH metric data to GW
-			err = pushDataToGW(msg)
+			if pushCache != nil && pushCache.skip(pushCacheKey(dest, msg), msg.RspData) {
+				pushSkippedCounter.Inc()
+				continue
+			}
+			if dryRun != nil {
+				dryRun.recordPush(dest, msg)
+				err = nil
+			} else {
+				err = pushDataToGW(dest, msg)
+			}
 			if err != nil {
 				log.Errorf("Failed to push data to the remote Prometheus GW, error: %s", err.Error())
+				recorder.Warningf(podReferenceOf(msg.Namespace, msg.PodName, msg.PodUID), eventReasonPushFailed, "Failed to push metrics to push gateway \"%s\": %s", dest.Name, err.Error())
+			} else if pushCache != nil {
+				pushCache.remember(pushCacheKey(dest, msg), msg.RspData)
 			}
 		} else {
-			err = deletePrometheusMetric(msg)
+			if pushCache != nil {
+				pushCache.forget(pushCacheKey(dest, msg))
+			}
+			if dryRun != nil {
+				dryRun.recordDelete(dest, msg)
+				continue
+			}
+			err = deletePrometheusMetric(dest, msg)
 			if err != nil {
 				log.Errorf("Failed to remove remote Prometheus metric, error: %s", err.Error())
 			} else {
@@ -48,13 +100,50 @@ func readMessage(data chan *PrometheusData) {
 	}
 }
 
-func pushDataToGW(data *PrometheusData) error {
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/metrics/job/%s/instance/%s", args.RemotePrometheusPushGWAddr, data.ResourceName, data.PodName), bytes.NewReader(data.RspData))
+// groupingPath returns the grouping key of the POD (and the container if labelled) on the push gateway.
+func groupingPath(data *PrometheusData) string {
+	path := fmt.Sprintf("/metrics/job/%s/instance/%s", data.ResourceName, data.PodName)
+	if data.Container != "" {
+		path += "/container/" + data.Container
+	}
+	//the hostNetwork PODs share the node's IP, so label them explicitly.
+	if data.HostNetwork {
+		path += "/host_network/true"
+	}
+	if data.SourceGroup != "" {
+		path += "/source/" + data.SourceGroup
+	}
+	return path
+}
+
+// isGroupingLabel returns true if the label is a part of the grouping key, the push gateway rejects the series whose values of such labels differ from the key.
+func isGroupingLabel(name string) bool {
+	switch name {
+	case "job", "instance", "container", "host_network", "source":
+		return true
+	}
+	return false
+}
+
+func newPushRequest(dest *pushDestination, data *PrometheusData) (*http.Request, error) {
+	method := "POST"
+	if data.Replace {
+		method = "PUT"
+	}
+	return dest.newRequest(method, groupingPath(data), bytes.NewReader(data.RspData))
+}
+
+func newDeleteRequest(dest *pushDestination, data *PrometheusData) (*http.Request, error) {
+	return dest.newRequest("DELETE", groupingPath(data), nil)
+}
+
+func pushDataToGW(dest *pushDestination, data *PrometheusData) error {
+	req, err := newPushRequest(dest, data)
 	if err != nil {
 		pushFailedCounter.Inc()
 		return err
 	}
-	rsp, err := pushGWClient.Do(req)
+	rsp, err := dest.client.Do(req)
 	if err != nil {
 		pushFailedCounter.Inc()
 		return err
@@ -68,12 +157,12 @@ func pushDataToGW(data *PrometheusData) error {
 	return nil
 }
 
-func deletePrometheusMetric(data *PrometheusData) error {
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("http://%s/metrics/job/%s/instance/%s", args.RemotePrometheusPushGWAddr, data.ResourceName, data.PodName), nil)
+func deletePrometheusMetric(dest *pushDestination, data *PrometheusData) error {
+	req, err := newDeleteRequest(dest, data)
 	if err != nil {
 		return err
 	}
-	rsp, err := pushGWClient.Do(req)
+	rsp, err := dest.client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	pushCache          *pushDeduplicator
+	pushSkippedCounter = prometheus.NewCounter(prometheus.CounterOpts{Name: "push_prometheus_metrics_skipped_count_total", Help: "Total count of skipped pushes since the fetched metrics were identical to the last pushed ones."})
+)
+
+// pushDeduplicator remembers the content hash of the last successful push of every target.
+// It is ONLY accessed from the goroutine which reads the message channel, so no lock is needed.
+type pushDeduplicator struct {
+	maxSkips int
+	entries  map[string]*pushCacheEntry
+}
+
+type pushCacheEntry struct {
+	hash    [sha256.Size]byte
+	skipped int
+}
+
+func newPushDeduplicator(maxSkips int) *pushDeduplicator {
+	return &pushDeduplicator{maxSkips: maxSkips, entries: make(map[string]*pushCacheEntry)}
+}
+
+// skip returns true if the data is identical to the last pushed one and the target has not reached the maximum skips,
+// so that the "push_time_seconds" on the push gateway stays fresh.
+func (d *pushDeduplicator) skip(key string, data []byte) bool {
+	entry, ok := d.entries[key]
+	if !ok || entry.hash != sha256.Sum256(data) {
+		return false
+	}
+	if d.maxSkips > 0 && entry.skipped >= d.maxSkips {
+		return false
+	}
+	entry.skipped++
+	return true
+}
+
+func (d *pushDeduplicator) remember(key string, data []byte) {
+	d.entries[key] = &pushCacheEntry{hash: sha256.Sum256(data)}
+}
+
+func (d *pushDeduplicator) forget(key string) {
+	delete(d.entries, key)
+}
+
+func pushCacheKey(dest *pushDestination, data *PrometheusData) string {
+	return dest.Name + groupingPath(data) + "#" + data.Source
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTenantHeader = "X-Scope-OrgID"
+)
+
+// pushDestinationConfig describes how to reach a remote Prometheus push gateway.
+type pushDestinationConfig struct {
+	Name         string            `yaml:"name"`
+	Address      string            `yaml:"address"`
+	Timeout      time.Duration     `yaml:"-"`
+	CAFile       string            `yaml:"caFile"`
+	CertFile     string            `yaml:"certFile"`
+	KeyFile      string            `yaml:"keyFile"`
+	Insecure     bool              `yaml:"insecure"`
+	Username     string            `yaml:"username"`
+	PasswordFile string            `yaml:"passwordFile"`
+	TokenFile    string            `yaml:"tokenFile"`
+	Headers      map[string]string `yaml:"headers"`
+	Tenant       string            `yaml:"tenant"`
+	TenantHeader string            `yaml:"tenantHeader"`
+	// AnnotationNamespaces are the namespaces whose PODs may pick this destination by the tenant annotation, "*" for all.
+	AnnotationNamespaces []string `yaml:"annotationNamespaces"`
+}
+
+// pushDestination is a remote Prometheus push gateway together with its TLS, authentication and header settings.
+type pushDestination struct {
+	Name         string
+	URL          string
+	client       *http.Client
+	username     string
+	passwordFile *reloadableFile
+	tokenFile    *reloadableFile
+	headers      http.Header
+	//namespaces allowed to pick the destination by the tenant annotation.
+	annotationNamespaces map[string]bool
+}
+
+func newPushDestination(cfg *pushDestinationConfig) (*pushDestination, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("address of push gateway \"%s\" CANNOT be empty", cfg.Name)
+	}
+	dest := &pushDestination{Name: cfg.Name, URL: strings.TrimSuffix(cfg.Address, "/"), username: cfg.Username, headers: http.Header{}}
+	//keep compatible with the address without any scheme, e.g. "xxx.xxx.xxx.xxx:9091".
+	if !strings.HasPrefix(dest.URL, "http://") && !strings.HasPrefix(dest.URL, "https://") {
+		dest.URL = "http://" + dest.URL
+	}
+	if cfg.PasswordFile != "" {
+		dest.passwordFile = &reloadableFile{Path: cfg.PasswordFile}
+	}
+	if cfg.TokenFile != "" {
+		dest.tokenFile = &reloadableFile{Path: cfg.TokenFile}
+	}
+	if len(cfg.AnnotationNamespaces) > 0 {
+		dest.annotationNamespaces = make(map[string]bool, len(cfg.AnnotationNamespaces))
+		for _, ns := range cfg.AnnotationNamespaces {
+			dest.annotationNamespaces[ns] = true
+		}
+	}
+	for k, v := range cfg.Headers {
+		dest.headers.Set(k, v)
+	}
+	if cfg.Tenant != "" {
+		if cfg.TenantHeader == "" {
+			cfg.TenantHeader = defaultTenantHeader
+		}
+		dest.headers.Set(cfg.TenantHeader, cfg.Tenant)
+	}
+	transport := &http.Transport{MaxIdleConns: 10, TLSHandshakeTimeout: 0}
+	if strings.HasPrefix(dest.URL, "https://") {
+		tlsConfig := &tls.Config{InsecureSkipVerify: cfg.Insecure}
+		if cfg.CAFile != "" {
+			data, err := ioutil.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read CA file of push gateway \"%s\", error: %s", cfg.Name, err.Error())
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("No valid CA certificate found in file: %s", cfg.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if cfg.CertFile != "" && cfg.KeyFile != "" {
+			cert := &reloadableFile{Path: cfg.CertFile}
+			key := &reloadableFile{Path: cfg.KeyFile}
+			//reload client certificate on each handshake, so that the rotated certificate takes effect without restarting.
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				certData, err := cert.Get()
+				if err != nil {
+					return nil, err
+				}
+				keyData, err := key.Get()
+				if err != nil {
+					return nil, err
+				}
+				pair, err := tls.X509KeyPair(certData, keyData)
+				if err != nil {
+					return nil, err
+				}
+				return &pair, nil
+			}
+			if _, err := tlsConfig.GetClientCertificate(nil); err != nil {
+				return nil, fmt.Errorf("Failed to load client certificate of push gateway \"%s\", error: %s", cfg.Name, err.Error())
+			}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	dest.client = &http.Client{Timeout: cfg.Timeout, Transport: transport}
+	return dest, nil
+}
+
+// newRequest creates a request to the push gateway with the authentication and custom headers applied.
+func (d *pushDestination) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, d.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range d.headers {
+		req.Header[k] = v
+	}
+	if d.tokenFile != nil {
+		token, err := d.tokenFile.Get()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	} else if d.username != "" {
+		password := ""
+		if d.passwordFile != nil {
+			data, err := d.passwordFile.Get()
+			if err != nil {
+				return nil, err
+			}
+			password = strings.TrimSpace(string(data))
+		}
+		req.SetBasicAuth(d.username, password)
+	}
+	return req, nil
+}
+
+// reloadableFile caches the content of a file and re-reads it once the file has been changed.
+type reloadableFile struct {
+	Path    string
+	lock    sync.Mutex
+	modTime time.Time
+	size    int64
+	data    []byte
+}
+
+func (f *reloadableFile) Get() ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	if f.data != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.data, nil
+	}
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	f.data = data
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return f.data, nil
+}
+
+// headerFlags collects repeatable "Name: Value" command line arguments.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	pairs := make([]string, 0, len(h))
+	for k, v := range h {
+		pairs = append(pairs, k+": "+v)
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlags) Set(value string) error {
+	idx := strings.Index(value, ":")
+	if idx <= 0 {
+		return fmt.Errorf("header must be formatted as \"Name: Value\"")
+	}
+	h[strings.TrimSpace(value[:idx])] = strings.TrimSpace(value[idx+1:])
+	return nil
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/flowcontrol"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	relaySource          = "pushgateway"
+	relayPathPrefix      = "/metrics/"
+	relaySweepInterval   = time.Minute
+	relayMaxResponseSize = 4096 //bytes of the gateway's response returned to the caller.
+	//the caller's job is relayed as this label, since "job" of the grouping key is the POD's resource name.
+	relayJobLabel = "pushed_job"
+)
+
+var (
+	relayRequestCounter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "crystal_bridge_relay_requests_total", Help: "Total count of the Pushgateway API requests received by the relay by result."}, []string{"result"})
+)
+
+// relayLabel is a label of the grouping key, the order given by the caller is kept.
+type relayLabel struct {
+	Name  string
+	Value string
+}
+
+type relayPodState struct {
+	Pod    *corev1.Pod
+	Groups map[string]*pushDestination //destination of every relayed grouping key.
+}
+
+// pushgatewayRelay serves the Pushgateway API for the short-lived jobs on current node, the caller's grouping keys are
+// appended to the grouping keys of the PODs which pushed them, and removed once the PODs have been deleted.
+// The relayed grouping keys are ONLY kept in memory, those NOT pushed again after a restart are left on the push gateway
+// once their PODs have been deleted.
+type pushgatewayRelay struct {
+	lock     sync.Mutex
+	pods     map[types.UID]*relayPodState
+	limiters map[string]flowcontrol.RateLimiter //per namespace.
+}
+
+func initializePushgatewayRelay() {
+	if !args.EnablePushgatewayRelay {
+		return
+	}
+	log.Infof("Initializing Pushgateway relay on %s...", relayPathPrefix+"job/")
+	prometheus.MustRegister(relayRequestCounter)
+	r := &pushgatewayRelay{pods: map[types.UID]*relayPodState{}, limiters: map[string]flowcontrol.RateLimiter{}}
+	//served on the same port as the bridge's own metrics.
+	http.HandleFunc(relayPathPrefix+"job/", r.handle)
+	http.HandleFunc(relayPathPrefix+"job@base64/", r.handle)
+	go r.run()
+}
+
+func (r *pushgatewayRelay) handle(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "PUT" && req.Method != "POST" && req.Method != "DELETE" {
+		http.Error(w, "ONLY PUT, POST & DELETE are allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	pod := podByIP(req.RemoteAddr)
+	if pod == nil {
+		relayRequestCounter.WithLabelValues("unknown").Inc()
+		http.Error(w, "the sender is NOT a POD on current node.", http.StatusForbidden)
+		return
+	}
+	labels, err := parseRelayGroupingKey(req.URL.EscapedPath())
+	if err != nil {
+		relayRequestCounter.WithLabelValues("invalid").Inc()
+		http.Error(w, "invalid grouping key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !r.allow(pod.Namespace) {
+		relayRequestCounter.WithLabelValues("throttled").Inc()
+		http.Error(w, fmt.Sprintf("too many requests from namespace: %s", pod.Namespace), http.StatusTooManyRequests)
+		return
+	}
+	deleting := req.Method == "DELETE"
+	var families []*dto.MetricFamily
+	if !deleting {
+		if families, err = decodeRelayBody(req, labels); err != nil {
+			relayRequestCounter.WithLabelValues("invalid").Inc()
+			if _, ok := err.(*limitExceededError); ok {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, "invalid metrics: "+err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+	}
+	e := newReceiverEvent(pod)
+	applyMetricPrefix(families, e.LabeledNamespace)
+	data, err := buildPrometheusData(e, scrapeTarget{}, families, deleting)
+	if err != nil {
+		relayRequestCounter.WithLabelValues("invalid").Inc()
+		http.Error(w, "invalid metrics: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	data.Source = relaySource
+	data.SourceGroup = relaySource
+	dest := router.route(data)
+	if dest == nil {
+		relayRequestCounter.WithLabelValues("dropped").Inc()
+		http.Error(w, "NO push gateway matched.", http.StatusServiceUnavailable)
+		return
+	}
+	path := relayGroupingPath(data, labels)
+	added := false
+	if !deleting {
+		var ok bool
+		if ok, added = r.reserve(pod, dest, path); !ok {
+			relayRequestCounter.WithLabelValues("limited").Inc()
+			http.Error(w, fmt.Sprintf("namespace %s has reached the limit of %d grouping keys.", pod.Namespace, args.RelayGroupLimit), http.StatusForbidden)
+			return
+		}
+	}
+	status, content, err := relayRequest(dest, req.Method, path, data)
+	succeeded := err == nil && status/100 == 2
+	if succeeded && deleting {
+		r.release(pod.UID, path)
+	} else if !succeeded && added {
+		r.release(pod.UID, path)
+	}
+	if err != nil {
+		relayRequestCounter.WithLabelValues("failed").Inc()
+		log.Errorf("Failed to relay the Pushgateway request of POD: %s to push gateway \"%s\", error: %s", pod.Name, dest.Name, err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if succeeded {
+		relayRequestCounter.WithLabelValues("succeeded").Inc()
+	} else {
+		relayRequestCounter.WithLabelValues("failed").Inc()
+	}
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+// allow returns false if the namespace has exceeded its rate of the relayed requests.
+func (r *pushgatewayRelay) allow(namespace string) bool {
+	if args.RelayRateLimit <= 0 {
+		return true
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	limiter, ok := r.limiters[namespace]
+	if !ok {
+		limiter = flowcontrol.NewTokenBucketRateLimiter(float32(args.RelayRateLimit), int(math.Ceil(args.RelayRateLimit)))
+		r.limiters[namespace] = limiter
+	}
+	return limiter.TryAccept()
+}
+
+// reserve remembers the grouping key before relaying, so that the concurrent pushes can't exceed the limit of the namespace.
+// The first result is false if the limit has been reached, the second one is true if the key is new.
+func (r *pushgatewayRelay) reserve(pod *corev1.Pod, dest *pushDestination, path string) (bool, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	state, ok := r.pods[pod.UID]
+	if ok {
+		if _, exists := state.Groups[path]; exists {
+			state.Groups[path] = dest
+			return true, false
+		}
+	}
+	if args.RelayGroupLimit > 0 {
+		count := 0
+		for _, s := range r.pods {
+			if s.Pod.Namespace == pod.Namespace {
+				count += len(s.Groups)
+			}
+		}
+		if count >= args.RelayGroupLimit {
+			return false, false
+		}
+	}
+	if !ok {
+		state = &relayPodState{Pod: pod, Groups: map[string]*pushDestination{}}
+		r.pods[pod.UID] = state
+	}
+	state.Groups[path] = dest
+	return true, true
+}
+
+func (r *pushgatewayRelay) release(uid types.UID, path string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if state, ok := r.pods[uid]; ok {
+		delete(state.Groups, path)
+		if len(state.Groups) == 0 {
+			delete(r.pods, uid)
+		}
+	}
+}
+
+func (r *pushgatewayRelay) run() {
+	ticker := time.NewTicker(relaySweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.sweep()
+	}
+}
+
+// sweep removes the grouping keys relayed for the PODs which have been deleted, and the rate limiters of the namespaces
+// which have nothing relayed any more.
+func (r *pushgatewayRelay) sweep() {
+	gone := []*relayPodState{}
+	r.lock.Lock()
+	namespaces := map[string]bool{}
+	for uid, state := range r.pods {
+		if !podExists(state.Pod) {
+			gone = append(gone, state)
+			delete(r.pods, uid)
+			continue
+		}
+		namespaces[state.Pod.Namespace] = true
+	}
+	for namespace := range r.limiters {
+		if !namespaces[namespace] {
+			delete(r.limiters, namespace)
+		}
+	}
+	r.lock.Unlock()
+	for _, state := range gone {
+		data, err := buildPrometheusData(newReceiverEvent(state.Pod), scrapeTarget{}, nil, true)
+		if err != nil {
+			continue
+		}
+		data.Source = relaySource
+		data.SourceGroup = relaySource
+		for path, dest := range state.Groups {
+			log.Debugf("Removing relayed metrics of deleted POD: %s, grouping key: %s", state.Pod.Name, path)
+			status, _, err := relayRequest(dest, "DELETE", path, data)
+			if err == nil && status/100 != 2 {
+				err = fmt.Errorf("HTTP RSP status-code: %d", status)
+			}
+			if err != nil {
+				log.Errorf("Failed to remove relayed metrics of POD: %s, grouping key: %s, error: %s", state.Pod.Name, path, err.Error())
+			}
+		}
+	}
+}
+
+// relayRequest sends the request to the push gateway, and returns its status code & (truncated) response.
+func relayRequest(dest *pushDestination, method, path string, data *PrometheusData) (int, []byte, error) {
+	if dryRun != nil {
+		if method == "DELETE" {
+			dryRun.recordDeleteTo(dest, data, path)
+		} else {
+			dryRun.recordPushTo(dest, data, path)
+		}
+		return http.StatusAccepted, nil, nil
+	}
+	var body io.Reader
+	if method != "DELETE" {
+		body = bytes.NewReader(data.RspData)
+	}
+	req, err := dest.newRequest(method, path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", string(expfmt.FmtText))
+	}
+	rsp, err := dest.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rsp.Body.Close()
+	content, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, relayMaxResponseSize))
+	return rsp.StatusCode, content, nil
+}
+
+// decodeRelayBody decodes the pushed metrics (text or protobuf) with the scrape limits enforced, the series MUST NOT
+// carry the labels of the POD's grouping key, or the caller's grouping labels with different values.
+func decodeRelayBody(req *http.Request, labels []relayLabel) ([]*dto.MetricFamily, error) {
+	var body io.Reader = req.Body
+	if strings.EqualFold(req.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	families, err := decodeMetricFamilies(body, detectMetricsFormat(req.Header), args.ScrapeLimits)
+	if err != nil {
+		return nil, err
+	}
+	key := make(map[string]string, len(labels))
+	for _, l := range labels[1:] {
+		key[l.Name] = l.Value
+	}
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				if isGroupingLabel(l.GetName()) || l.GetName() == relayJobLabel {
+					return nil, fmt.Errorf("metric %s carries the reserved label \"%s\"", mf.GetName(), l.GetName())
+				}
+				if value, ok := key[l.GetName()]; ok && value != l.GetValue() {
+					return nil, fmt.Errorf("metric %s has label \"%s\" inconsistent with the grouping key", mf.GetName(), l.GetName())
+				}
+			}
+		}
+	}
+	return families, nil
+}
+
+// parseRelayGroupingKey parses the grouping key from the escaped URL path like the Pushgateway does, e.g.
+// "/metrics/job/backup/stage/full" or "/metrics/job/backup/path@base64/L3Zhci9kYXRh".
+func parseRelayGroupingKey(path string) ([]relayLabel, error) {
+	segments := strings.Split(strings.TrimPrefix(path, relayPathPrefix), "/")
+	if len(segments)%2 != 0 {
+		return nil, fmt.Errorf("odd number of components in path: %s", path)
+	}
+	labels := make([]relayLabel, 0, len(segments)/2)
+	seen := map[string]bool{}
+	for i := 0; i < len(segments); i += 2 {
+		name, err := url.PathUnescape(segments[i])
+		if err != nil {
+			return nil, err
+		}
+		value, err := url.PathUnescape(segments[i+1])
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(name, "@base64") {
+			name = strings.TrimSuffix(name, "@base64")
+			decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value of label \"%s\": %s", name, err.Error())
+			}
+			value = string(decoded)
+		}
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid label name \"%s\"", name)
+		}
+		if (i > 0 && isGroupingLabel(name)) || name == relayJobLabel {
+			return nil, fmt.Errorf("label \"%s\" is reserved by the relay", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate label \"%s\"", name)
+		}
+		seen[name] = true
+		labels = append(labels, relayLabel{Name: name, Value: value})
+	}
+	if len(labels) == 0 || labels[0].Name != "job" || labels[0].Value == "" {
+		return nil, fmt.Errorf("job name is required")
+	}
+	return labels, nil
+}
+
+// relayGroupingPath appends the caller's grouping key to the POD's one, the caller's job is renamed to "pushed_job",
+// and the values which can't be escaped are base64 encoded.
+func relayGroupingPath(data *PrometheusData, labels []relayLabel) string {
+	sb := strings.Builder{}
+	sb.WriteString(groupingPath(data))
+	for _, l := range labels {
+		if l.Name == "job" {
+			l.Name = relayJobLabel
+		}
+		if l.Value == "" || strings.Contains(l.Value, "/") {
+			encoded := base64.URLEncoding.EncodeToString([]byte(l.Value))
+			//"=" stands for the empty value.
+			if encoded == "" {
+				encoded = "="
+			}
+			sb.WriteString("/" + l.Name + "@base64/" + encoded)
+			continue
+		}
+		sb.WriteString("/" + l.Name + "/" + url.PathEscape(l.Value))
+	}
+	return sb.String()
+}
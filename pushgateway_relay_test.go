@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestRelayGroupingPath(t *testing.T) {
+	data := &PrometheusData{ResourceName: "default_Job_backup-1", PodName: "backup-1-x7k2p", SourceGroup: relaySource}
+	tests := []struct {
+		path string
+		want string
+		err  bool
+	}{
+		{path: "/metrics/job/backup", want: "/metrics/job/default_Job_backup-1/instance/backup-1-x7k2p/source/pushgateway/pushed_job/backup"},
+		{path: "/metrics/job/backup/namespace/ops/stage/full", want: "/metrics/job/default_Job_backup-1/instance/backup-1-x7k2p/source/pushgateway/pushed_job/backup/namespace/ops/stage/full"},
+		{path: "/metrics/job/backup/path@base64/L3Zhci9kYXRh", want: "/metrics/job/default_Job_backup-1/instance/backup-1-x7k2p/source/pushgateway/pushed_job/backup/path@base64/L3Zhci9kYXRh"},
+		{path: "/metrics/job/backup/instance/other", err: true},
+		{path: "/metrics/job/backup/pushed_job/other", err: true},
+		{path: "/metrics/job/backup/stage", err: true},
+		{path: "/metrics/stage/full", err: true},
+	}
+	for _, test := range tests {
+		labels, err := parseRelayGroupingKey(test.path)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: no error", test.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.path, err.Error())
+			continue
+		}
+		if got := relayGroupingPath(data, labels); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.path, got, test.want)
+		}
+	}
+}
+
+func TestDecodeRelayBody(t *testing.T) {
+	if args == nil {
+		args = &CommandLineArgs{}
+	}
+	labels, err := parseRelayGroupingKey("/metrics/job/backup/stage/full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		body string
+		err  bool
+	}{
+		{body: "backup_duration_seconds{namespace=\"ops\",stage=\"full\"} 12\n"},
+		{body: "backup_duration_seconds{stage=\"incremental\"} 12\n", err: true},
+		{body: "backup_duration_seconds{job=\"backup\"} 12\n", err: true},
+		{body: "backup_duration_seconds{instance=\"x\"} 12\n", err: true},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("PUT", "/metrics/job/backup/stage/full", strings.NewReader(test.body))
+		_, err := decodeRelayBody(req, labels)
+		if test.err != (err != nil) {
+			t.Errorf("%q: unexpected error: %v", test.body, err)
+		}
+	}
+}
+
+func TestRelaySweepLimiters(t *testing.T) {
+	if args == nil {
+		args = &CommandLineArgs{}
+	}
+	saved := podIndexer
+	podIndexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	t.Cleanup(func() { podIndexer = saved })
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "batch", UID: "uid"}}
+	podIndexer.Add(pod)
+	r := &pushgatewayRelay{
+		pods: map[types.UID]*relayPodState{pod.UID: {Pod: pod, Groups: map[string]*pushDestination{}}},
+		limiters: map[string]flowcontrol.RateLimiter{
+			"batch":   flowcontrol.NewTokenBucketRateLimiter(1, 1),
+			"default": flowcontrol.NewTokenBucketRateLimiter(1, 1),
+		},
+	}
+	r.sweep()
+	if _, ok := r.limiters["default"]; ok || len(r.limiters) != 1 {
+		t.Errorf("unexpected limiters after sweeping: %v", r.limiters)
+	}
+}
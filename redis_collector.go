@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisMaxBulkSize caps the length of a bulk string reply if no body size limit is set, since the length is given by the POD.
+const redisMaxBulkSize = 64 << 20
+
+// redisInfoFields maps the fields of "INFO" to the names used by the widely deployed redis_exporter.
+var redisInfoFields = map[string]struct {
+	Name  string
+	Type  dto.MetricType
+	Scale float64
+}{
+	//server
+	"uptime_in_seconds": {"redis_uptime_in_seconds", dto.MetricType_GAUGE, 1},
+	//clients
+	"connected_clients": {"redis_connected_clients", dto.MetricType_GAUGE, 1},
+	"blocked_clients":   {"redis_blocked_clients", dto.MetricType_GAUGE, 1},
+	//memory
+	"used_memory":             {"redis_memory_used_bytes", dto.MetricType_GAUGE, 1},
+	"used_memory_rss":         {"redis_memory_used_rss_bytes", dto.MetricType_GAUGE, 1},
+	"used_memory_peak":        {"redis_memory_used_peak_bytes", dto.MetricType_GAUGE, 1},
+	"used_memory_lua":         {"redis_memory_used_lua_bytes", dto.MetricType_GAUGE, 1},
+	"maxmemory":               {"redis_memory_max_bytes", dto.MetricType_GAUGE, 1},
+	"mem_fragmentation_ratio": {"redis_mem_fragmentation_ratio", dto.MetricType_GAUGE, 1},
+	//stats
+	"total_connections_received": {"redis_connections_received_total", dto.MetricType_COUNTER, 1},
+	"total_commands_processed":   {"redis_commands_processed_total", dto.MetricType_COUNTER, 1},
+	"rejected_connections":       {"redis_rejected_connections_total", dto.MetricType_COUNTER, 1},
+	"expired_keys":               {"redis_expired_keys_total", dto.MetricType_COUNTER, 1},
+	"evicted_keys":               {"redis_evicted_keys_total", dto.MetricType_COUNTER, 1},
+	"keyspace_hits":              {"redis_keyspace_hits_total", dto.MetricType_COUNTER, 1},
+	"keyspace_misses":            {"redis_keyspace_misses_total", dto.MetricType_COUNTER, 1},
+	//replication
+	"connected_slaves":   {"redis_connected_slaves", dto.MetricType_GAUGE, 1},
+	"master_repl_offset": {"redis_master_repl_offset", dto.MetricType_GAUGE, 1},
+}
+
+// collectRedis connects to the Redis port of the target with RESP, and converts the reply of "INFO ALL".
+// The password (and the username of Redis 6 ACL) is read from the Secret referenced by the "/auth-secret" annotation.
+func collectRedis(c *scrapeClient, e *PODEvent, t scrapeTarget) ([]*dto.MetricFamily, error) {
+	address := net.JoinHostPort(scrapeHost(e), strconv.Itoa(t.Port))
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if e.Scheme == "https" {
+		tlsConfig := &tls.Config{InsecureSkipVerify: e.TLSInsecure}
+		if transport, ok := c.client.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+			tlsConfig = transport.TLSClientConfig.Clone()
+		}
+		//the certificate is verified against the POD's IP if no server name is annotated.
+		if e.ServerName != "" {
+			tlsConfig.ServerName = e.ServerName
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if c.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	reader := &limitedReader{reader: conn, limits: e.Limits}
+	client := &redisConn{writer: conn, reader: bufio.NewReader(reader), limits: e.Limits}
+	if c.credentials != nil && c.credentials.Password != "" {
+		command := []string{"AUTH", c.credentials.Password}
+		if c.credentials.Username != "" {
+			command = []string{"AUTH", c.credentials.Username, c.credentials.Password}
+		}
+		if _, err = client.do(command...); err != nil {
+			return nil, fmt.Errorf("failed to authenticate: %s", err.Error())
+		}
+	}
+	info, err := client.do("INFO", "ALL")
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseRedisInfo(info, e.Limits)
+}
+
+// redisConn is a minimal RESP client which supports ONLY the commands replied with simple or bulk strings.
+type redisConn struct {
+	writer io.Writer
+	reader *bufio.Reader
+	limits scrapeLimits
+}
+
+func (c *redisConn) do(command ...string) (string, error) {
+	sb := strings.Builder{}
+	sb.WriteString("*" + strconv.Itoa(len(command)) + "\r\n")
+	for _, arg := range command {
+		sb.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := io.WriteString(c.writer, sb.String()); err != nil {
+		return "", err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+	if line == "" {
+		return "", fmt.Errorf("empty RESP reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("%s", line[1:])
+	case '$':
+		size, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || size < 0 {
+			return "", fmt.Errorf("invalid RESP bulk string length \"%s\"", line[1:])
+		}
+		if c.limits.BodySizeLimit > 0 && size > c.limits.BodySizeLimit {
+			return "", &limitExceededError{Limit: limitBodySize, Actual: size, Max: c.limits.BodySizeLimit}
+		}
+		if size > redisMaxBulkSize {
+			return "", fmt.Errorf("RESP bulk string of %d bytes is too large", size)
+		}
+		//the buffer grows with the received data, instead of trusting the length.
+		buf := &bytes.Buffer{}
+		if _, err = io.CopyN(buf, c.reader, size+2); err != nil {
+			return "", err
+		}
+		return string(buf.Bytes()[:size]), nil
+	}
+	return "", fmt.Errorf("unexpected RESP reply \"%s\"", line)
+}
+
+func (c *redisConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseRedisInfo converts the reply of "INFO", which consists of "# Section" headers and "field:value" lines.
+func parseRedisInfo(info string, limits scrapeLimits) ([]*dto.MetricFamily, error) {
+	builder := newFamilyBuilder()
+	instance := map[string]string{}
+	section := ""
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			section = strings.ToLower(strings.TrimSpace(line[1:]))
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		field, value := parts[0], parts[1]
+		switch {
+		case field == "redis_version" || field == "redis_mode" || field == "role":
+			instance[field] = value
+		case field == "master_link_status":
+			up := 0.0
+			if value == "up" {
+				up = 1
+			}
+			builder.add("redis_master_link_up", "", dto.MetricType_GAUGE, up, nil)
+		case section == "keyspace" && strings.HasPrefix(field, "db"):
+			addRedisKeyspace(builder, field, parseRedisProperties(value))
+		case section == "replication" && strings.HasPrefix(field, "slave") && strings.Contains(value, "="):
+			addRedisSlave(builder, parseRedisProperties(value))
+		case section == "commandstats" && strings.HasPrefix(field, "cmdstat_"):
+			addRedisCommandStats(builder, strings.TrimPrefix(field, "cmdstat_"), parseRedisProperties(value))
+		default:
+			mapping, ok := redisInfoFields[field]
+			if !ok {
+				continue
+			}
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				builder.add(mapping.Name, "", mapping.Type, f*mapping.Scale, nil)
+			}
+		}
+	}
+	if len(instance) == 0 {
+		return nil, fmt.Errorf("invalid INFO reply, no server information is found")
+	}
+	builder.add("redis_instance_info", "Information about the Redis instance.", dto.MetricType_GAUGE, 1, instance)
+	return builder.build(limits)
+}
+
+// parseRedisProperties parses the values formatted as "keys=1,expires=0,avg_ttl=0".
+func parseRedisProperties(value string) map[string]string {
+	properties := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 {
+			properties[kv[0]] = kv[1]
+		}
+	}
+	return properties
+}
+
+func addRedisKeyspace(builder *familyBuilder, db string, properties map[string]string) {
+	labels := map[string]string{"db": db}
+	if f, err := strconv.ParseFloat(properties["keys"], 64); err == nil {
+		builder.add("redis_db_keys", "Total number of keys by DB.", dto.MetricType_GAUGE, f, labels)
+	}
+	if f, err := strconv.ParseFloat(properties["expires"], 64); err == nil {
+		builder.add("redis_db_keys_expiring", "Total number of expiring keys by DB.", dto.MetricType_GAUGE, f, labels)
+	}
+	if f, err := strconv.ParseFloat(properties["avg_ttl"], 64); err == nil {
+		builder.add("redis_db_avg_ttl_seconds", "Avg TTL in seconds.", dto.MetricType_GAUGE, f/1000, labels)
+	}
+}
+
+func addRedisSlave(builder *familyBuilder, properties map[string]string) {
+	labels := map[string]string{"slave_ip": properties["ip"], "slave_port": properties["port"], "slave_state": properties["state"]}
+	if f, err := strconv.ParseFloat(properties["offset"], 64); err == nil {
+		builder.add("redis_connected_slave_offset_bytes", "Offset of connected slave.", dto.MetricType_GAUGE, f, labels)
+	}
+	if f, err := strconv.ParseFloat(properties["lag"], 64); err == nil {
+		builder.add("redis_connected_slave_lag_seconds", "Lag of connected slave.", dto.MetricType_GAUGE, f, labels)
+	}
+}
+
+func addRedisCommandStats(builder *familyBuilder, command string, properties map[string]string) {
+	labels := map[string]string{"cmd": command}
+	if f, err := strconv.ParseFloat(properties["calls"], 64); err == nil {
+		builder.add("redis_commands_total", "Total number of calls per command.", dto.MetricType_COUNTER, f, labels)
+	}
+	if f, err := strconv.ParseFloat(properties["usec"], 64); err == nil {
+		builder.add("redis_commands_duration_seconds_total", "Total amount of time in seconds spent per command.", dto.MetricType_COUNTER, f/1e6, labels)
+	}
+	//available since Redis 6.2.
+	if f, err := strconv.ParseFloat(properties["rejected_calls"], 64); err == nil {
+		builder.add("redis_commands_rejected_calls_total", "Total number of rejected calls per command.", dto.MetricType_COUNTER, f, labels)
+	}
+	if f, err := strconv.ParseFloat(properties["failed_calls"], 64); err == nil {
+		builder.add("redis_commands_failed_calls_total", "Total number of failed calls per command.", dto.MetricType_COUNTER, f, labels)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const redisTestInfo = `# Server
+redis_version:6.2.6
+redis_mode:standalone
+uptime_in_seconds:3600
+
+# Clients
+connected_clients:5
+
+# Memory
+used_memory:1048576
+
+# Stats
+total_commands_processed:100
+keyspace_hits:7
+
+# Replication
+role:master
+connected_slaves:1
+slave0:ip=10.0.0.2,port=6379,state=online,offset=300,lag=1
+master_repl_offset:300
+
+# Commandstats
+cmdstat_get:calls=10,usec=500,usec_per_call=50.00,rejected_calls=1,failed_calls=0
+
+# Keyspace
+db0:keys=12,expires=3,avg_ttl=2500
+`
+
+// fakeRedis serves the RESP commands with the replies, and records the received commands.
+type fakeRedis struct {
+	listener net.Listener
+	commands chan []string
+	reply    func(command []string) string
+}
+
+func newFakeRedis(t *testing.T, reply func(command []string) string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{listener: listener, commands: make(chan []string, 16), reply: reply}
+	t.Cleanup(func() { listener.Close() })
+	go r.serve()
+	return r
+}
+
+func (r *fakeRedis) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			for {
+				command, err := readRESPCommand(reader)
+				if err != nil {
+					return
+				}
+				r.commands <- command
+				io.WriteString(conn, r.reply(command))
+			}
+		}(conn)
+	}
+}
+
+func readRESPCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	command := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if _, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		command = append(command, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return command, nil
+}
+
+func bulkString(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func (r *fakeRedis) collect(t *testing.T, creds *scrapeCredentials) (string, error) {
+	port := r.listener.Addr().(*net.TCPAddr).Port
+	e := &PODEvent{Pod: &corev1.Pod{Status: corev1.PodStatus{PodIP: "127.0.0.1"}}, Scheme: "http"}
+	families, err := collectRedis(&scrapeClient{timeout: time.Second, credentials: creds}, e, scrapeTarget{Port: port})
+	if err != nil {
+		return "", err
+	}
+	return encodeForTest(t, families), nil
+}
+
+func TestCollectRedisAuth(t *testing.T) {
+	tests := []struct {
+		creds *scrapeCredentials
+		auth  []string
+	}{
+		{nil, nil},
+		{&scrapeCredentials{Password: "secret"}, []string{"AUTH", "secret"}},
+		{&scrapeCredentials{Username: "exporter", Password: "secret"}, []string{"AUTH", "exporter", "secret"}},
+	}
+	for _, test := range tests {
+		redis := newFakeRedis(t, func(command []string) string {
+			if command[0] == "AUTH" {
+				return "+OK\r\n"
+			}
+			return bulkString(redisTestInfo)
+		})
+		if _, err := redis.collect(t, test.creds); err != nil {
+			t.Fatalf("failed to collect: %s", err.Error())
+		}
+		if test.auth != nil {
+			if got := <-redis.commands; strings.Join(got, " ") != strings.Join(test.auth, " ") {
+				t.Errorf("unexpected AUTH command: %v", got)
+			}
+		}
+		if got := <-redis.commands; strings.Join(got, " ") != "INFO ALL" {
+			t.Errorf("unexpected command: %v", got)
+		}
+	}
+}
+
+func TestCollectRedisError(t *testing.T) {
+	redis := newFakeRedis(t, func(command []string) string {
+		if command[0] == "AUTH" {
+			return "-WRONGPASS invalid username-password pair\r\n"
+		}
+		return "-NOAUTH Authentication required.\r\n"
+	})
+	if _, err := redis.collect(t, &scrapeCredentials{Password: "wrong"}); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := redis.collect(t, nil); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCollectRedisOversizedBulk(t *testing.T) {
+	for _, length := range []string{"9223372036854775807", "1073741824"} {
+		redis := newFakeRedis(t, func(command []string) string {
+			return "$" + length + "\r\nredis_version:7.0.0\r\n"
+		})
+		if _, err := redis.collect(t, nil); err == nil || !strings.Contains(err.Error(), "too large") {
+			t.Errorf("unexpected error of bulk string length %s: %v", length, err)
+		}
+	}
+	redis := newFakeRedis(t, func(command []string) string {
+		return "$4096\r\nredis_version:7.0.0\r\n"
+	})
+	e := &PODEvent{Pod: &corev1.Pod{Status: corev1.PodStatus{PodIP: "127.0.0.1"}}, Scheme: "http", Limits: scrapeLimits{BodySizeLimit: 1024}}
+	_, err := collectRedis(&scrapeClient{timeout: time.Second}, e, scrapeTarget{Port: redis.listener.Addr().(*net.TCPAddr).Port})
+	if _, ok := err.(*limitExceededError); !ok {
+		t.Errorf("unexpected error of bulk string exceeding the body size limit: %v", err)
+	}
+}
+
+func TestParseRedisInfo(t *testing.T) {
+	families, err := parseRedisInfo(strings.Replace(redisTestInfo, "\n", "\r\n", -1), scrapeLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := encodeForTest(t, families)
+	for _, want := range []string{
+		"redis_uptime_in_seconds 3600\n",
+		"redis_connected_clients 5\n",
+		"redis_memory_used_bytes 1.048576e+06\n",
+		"redis_commands_processed_total 100\n",
+		"redis_keyspace_hits_total 7\n",
+		"redis_connected_slaves 1\n",
+		`redis_connected_slave_offset_bytes{slave_ip="10.0.0.2",slave_port="6379",slave_state="online"} 300` + "\n",
+		`redis_connected_slave_lag_seconds{slave_ip="10.0.0.2",slave_port="6379",slave_state="online"} 1` + "\n",
+		`redis_commands_total{cmd="get"} 10` + "\n",
+		`redis_commands_duration_seconds_total{cmd="get"} 0.0005` + "\n",
+		`redis_commands_rejected_calls_total{cmd="get"} 1` + "\n",
+		`redis_db_keys{db="db0"} 12` + "\n",
+		`redis_db_keys_expiring{db="db0"} 3` + "\n",
+		`redis_db_avg_ttl_seconds{db="db0"} 2.5` + "\n",
+		`redis_instance_info{redis_mode="standalone",redis_version="6.2.6",role="master"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if _, err = parseRedisInfo("-ERR unknown\r\n", scrapeLimits{}); err == nil {
+		t.Errorf("invalid INFO reply accepted")
+	}
+}
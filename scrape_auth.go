@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"time"
+)
+
+// well-known keys of the Secret referenced by the "/auth-secret" annotation.
+const (
+	secretUsernameKey = "username"
+	secretPasswordKey = "password"
+	secretTokenKey    = "token"
+	secretCAKey       = "ca.crt"
+	secretCertKey     = "tls.crt"
+	secretPrivateKey  = "tls.key"
+	//the changes of the Secrets are applied after the TTL at most.
+	secretCacheTTL = time.Minute
+)
+
+var (
+	secretCache = newLookupCache(secretCacheTTL)
+)
+
+// scrapeCredentials holds the TLS & authentication settings of a scraping target.
+type scrapeCredentials struct {
+	ResourceVersion string
+	Username        string
+	Password        string
+	BearerToken     string
+	CACert          []byte
+	ClientCert      []byte
+	ClientKey       []byte
+}
+
+// loadScrapeCredentials reads the credentials from the referenced Secret, which is cached for secretCacheTTL.
+// nil will be returned if no Secret was referenced.
+func loadScrapeCredentials(namespace, name string) (*scrapeCredentials, error) {
+	if name == "" {
+		return nil, nil
+	}
+	obj, err := secretCache.get(namespace+"/"+name, func() (interface{}, error) {
+		return k8sClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load Secret %s/%s, error: %s", namespace, name, err.Error())
+	}
+	secret := obj.(*corev1.Secret)
+	return &scrapeCredentials{
+		ResourceVersion: secret.ResourceVersion,
+		Username:        string(secret.Data[secretUsernameKey]),
+		Password:        string(secret.Data[secretPasswordKey]),
+		BearerToken:     string(secret.Data[secretTokenKey]),
+		CACert:          secret.Data[secretCAKey],
+		ClientCert:      secret.Data[secretCertKey],
+		ClientKey:       secret.Data[secretPrivateKey]}, nil
+}
+
+// applyTo sets the authorization header of given request, bearer token takes precedence over basic auth.
+func (c *scrapeCredentials) applyTo(req *http.Request) {
+	if c == nil {
+		return
+	}
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+func newScrapeTransport(e *PODEvent, creds *scrapeCredentials) (*http.Transport, error) {
+	transport := &http.Transport{MaxIdleConns: 10, TLSHandshakeTimeout: 0}
+	if e.Scheme != "https" {
+		return transport, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: e.TLSInsecure, ServerName: e.ServerName}
+	if creds != nil {
+		if len(creds.CACert) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(creds.CACert) {
+				return nil, fmt.Errorf("No valid CA certificate found in key \"%s\"", secretCAKey)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		//mTLS
+		if len(creds.ClientCert) > 0 && len(creds.ClientKey) > 0 {
+			cert, err := tls.X509KeyPair(creds.ClientCert, creds.ClientKey)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to load client certificate, error: %s", err.Error())
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
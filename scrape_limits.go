@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"strconv"
+)
+
+const (
+	limitBodySize = "body_size_limit"
+	limitSample   = "sample_limit"
+	limitLabel    = "label_limit"
+)
+
+var (
+	fetchLimitExceededCounter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "fetch_prometheus_metrics_limit_exceeded_count_total", Help: "Total count of fetched metrics which have been rejected because of exceeding the scraping limits."}, []string{"limit"})
+)
+
+// scrapeLimits restricts the size of every scrape, zero means unlimited.
+type scrapeLimits struct {
+	BodySizeLimit int64
+	SampleLimit   int
+	LabelLimit    int
+}
+
+// limitExceededError marks a target failed because one of its scrapes exceeded the limit.
+type limitExceededError struct {
+	Limit  string
+	Actual int64
+	Max    int64
+}
+
+func (e *limitExceededError) Error() string {
+	return fmt.Sprintf("scrape exceeded %s (%d > %d)", e.Limit, e.Actual, e.Max)
+}
+
+func parseBodySizeLimit(value string) (int64, error) {
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid body size limit \"%s\", error: %s", value, err.Error())
+	}
+	return q.Value(), nil
+}
+
+func parseCountLimit(value string) (int, error) {
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid limit \"%s\", a non-negative integer is required", value)
+	}
+	return limit, nil
+}
+
+// limitedReader aborts reading as soon as the body size or the count of sample lines exceeds the limits,
+// so that a misbehaving target never gets fully loaded into memory.
+type limitedReader struct {
+	reader      io.Reader
+	limits      scrapeLimits
+	countLines  bool //ONLY the text formats can be counted by lines.
+	read        int64
+	samples     int
+	lineStarted bool
+	err         *limitExceededError
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.limits.BodySizeLimit > 0 && r.read > r.limits.BodySizeLimit {
+		r.err = &limitExceededError{Limit: limitBodySize, Actual: r.read, Max: r.limits.BodySizeLimit}
+		return 0, r.err
+	}
+	if r.countLines && r.limits.SampleLimit > 0 {
+		r.countSamples(p[:n])
+		if r.samples > r.limits.SampleLimit {
+			r.err = &limitExceededError{Limit: limitSample, Actual: int64(r.samples), Max: int64(r.limits.SampleLimit)}
+			return 0, r.err
+		}
+	}
+	return n, err
+}
+
+// countSamples counts every line which is neither blank nor a comment.
+func (r *limitedReader) countSamples(data []byte) {
+	for _, b := range data {
+		if b == '\n' {
+			r.lineStarted = false
+			continue
+		}
+		if r.lineStarted || b == ' ' || b == '\t' {
+			continue
+		}
+		r.lineStarted = true
+		if b != '#' {
+			r.samples++
+		}
+	}
+}
+
+// checkSeriesLimits checks the count of labels of every series and the accumulated count of samples (buckets & quantiles included).
+func checkSeriesLimits(mf *dto.MetricFamily, limits scrapeLimits, samples *int) error {
+	for _, m := range mf.Metric {
+		if limits.LabelLimit > 0 && len(m.Label) > limits.LabelLimit {
+			return &limitExceededError{Limit: limitLabel, Actual: int64(len(m.Label)), Max: int64(limits.LabelLimit)}
+		}
+		switch mf.GetType() {
+		case dto.MetricType_SUMMARY:
+			*samples += len(m.GetSummary().GetQuantile()) + 2
+		case dto.MetricType_HISTOGRAM:
+			*samples += len(m.GetHistogram().GetBucket()) + 2
+		default:
+			*samples++
+		}
+	}
+	if limits.SampleLimit > 0 && *samples > limits.SampleLimit {
+		return &limitExceededError{Limit: limitSample, Actual: int64(*samples), Max: int64(limits.SampleLimit)}
+	}
+	return nil
+}
+
+func encodeMetricFamilies(families []*dto.MetricFamily) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(buf, mf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
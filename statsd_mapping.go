@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	statsdActionMap  = "map"
+	statsdActionDrop = "drop"
+)
+
+var (
+	//references to the components matched by the wildcards, e.g. "$1" or "${1}".
+	statsdCaptureRegexp = regexp.MustCompile(`\$(\d+|\{\d+\})`)
+)
+
+// statsdMapping maps the dot separated StatsD names to the Prometheus names & labels like the statsd_exporter does, e.g.
+//
+//	mappings:
+//	- match: myapp.*.requests
+//	  name: myapp_requests_total
+//	  labels:
+//	    endpoint: $1
+//	- match: debug.*
+//	  action: drop
+//
+// The first matched rule wins, "*" matches exactly one component. The names without any matched rule are sanitized,
+// e.g. "myapp.db.latency" -> "myapp_db_latency".
+type statsdMapping struct {
+	Mappings []*statsdMappingRule `yaml:"mappings"`
+}
+
+type statsdMappingRule struct {
+	Match   string            `yaml:"match"`
+	Name    string            `yaml:"name"`
+	Labels  map[string]string `yaml:"labels"`
+	Action  string            `yaml:"action"`
+	pattern []string
+}
+
+func loadStatsDMapping(file string) (*statsdMapping, error) {
+	if file == "" {
+		return &statsdMapping{}, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatsDMapping(data)
+}
+
+func parseStatsDMapping(data []byte) (*statsdMapping, error) {
+	mapping := &statsdMapping{}
+	if err := yaml.UnmarshalStrict(data, mapping); err != nil {
+		return nil, err
+	}
+	for i, rule := range mapping.Mappings {
+		if rule.Match == "" {
+			return nil, fmt.Errorf("mappings[%d]: match CANNOT be empty", i)
+		}
+		rule.pattern = strings.Split(rule.Match, ".")
+		if rule.Action == "" {
+			rule.Action = statsdActionMap
+		}
+		switch rule.Action {
+		case statsdActionDrop:
+			continue
+		case statsdActionMap:
+		default:
+			return nil, fmt.Errorf("mappings[%d]: unsupported action \"%s\"", i, rule.Action)
+		}
+		wildcards := 0
+		for _, p := range rule.pattern {
+			if p == "*" {
+				wildcards++
+			}
+		}
+		if err := checkStatsDCaptures(rule.Name, wildcards); err != nil {
+			return nil, fmt.Errorf("mappings[%d]: invalid name \"%s\": %s", i, rule.Name, err.Error())
+		}
+		if rule.Name != "" && !model.IsValidMetricName(model.LabelValue(statsdCaptureRegexp.ReplaceAllString(rule.Name, "x"))) {
+			return nil, fmt.Errorf("mappings[%d]: invalid name \"%s\"", i, rule.Name)
+		}
+		for name, value := range rule.Labels {
+			if !model.LabelName(name).IsValid() || isGroupingLabel(name) {
+				return nil, fmt.Errorf("mappings[%d]: invalid label name \"%s\"", i, name)
+			}
+			if err := checkStatsDCaptures(value, wildcards); err != nil {
+				return nil, fmt.Errorf("mappings[%d]: invalid label \"%s\": %s", i, name, err.Error())
+			}
+		}
+	}
+	return mapping, nil
+}
+
+func checkStatsDCaptures(value string, wildcards int) error {
+	for _, ref := range statsdCaptureRegexp.FindAllString(value, -1) {
+		if index := statsdCaptureIndex(ref); index < 1 || index > wildcards {
+			return fmt.Errorf("the pattern has ONLY %d wildcard(s)", wildcards)
+		}
+	}
+	return nil
+}
+
+func statsdCaptureIndex(ref string) int {
+	index, _ := strconv.Atoi(strings.Trim(ref, "${}"))
+	return index
+}
+
+// apply maps the StatsD name, false will be returned if it should be dropped.
+// The labels given by the rule take precedence over the tags of the sample.
+func (mapping *statsdMapping) apply(name string, tags map[string]string) (string, map[string]string, bool) {
+	components := strings.Split(name, ".")
+	for _, rule := range mapping.Mappings {
+		captures, ok := rule.match(components)
+		if !ok {
+			continue
+		}
+		if rule.Action == statsdActionDrop {
+			return "", nil, false
+		}
+		expand := func(value string) string {
+			return statsdCaptureRegexp.ReplaceAllStringFunc(value, func(ref string) string {
+				return captures[statsdCaptureIndex(ref)-1]
+			})
+		}
+		mapped := sanitizeMetricName(name)
+		if rule.Name != "" {
+			mapped = sanitizeMetricName(expand(rule.Name))
+		}
+		labels := make(map[string]string, len(tags)+len(rule.Labels))
+		for k, v := range tags {
+			labels[k] = v
+		}
+		for k, v := range rule.Labels {
+			labels[k] = expand(v)
+		}
+		return mapped, labels, true
+	}
+	return sanitizeMetricName(name), tags, true
+}
+
+func (rule *statsdMappingRule) match(components []string) ([]string, bool) {
+	if len(components) != len(rule.pattern) {
+		return nil, false
+	}
+	captures := []string{}
+	for i, p := range rule.pattern {
+		if p == "*" {
+			captures = append(captures, components[i])
+		} else if p != components[i] {
+			return nil, false
+		}
+	}
+	return captures, true
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statsdSource          = "statsd"
+	statsdMaxPacketSize   = 65535
+	statsdMaxTimerSamples = 1000 //samples kept for calculating the quantiles of a timer in a flush interval.
+	statsdMaxConnections  = 256  //concurrent TCP connections, the new ones are closed immediately once exceeded.
+	statsdIdleTimeout     = time.Minute
+)
+
+var (
+	statsdQuantiles     = []float64{0.5, 0.9, 0.99}
+	statsdSampleCounter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "crystal_bridge_statsd_samples_total", Help: "Total count of the received StatsD samples by result."}, []string{"result"})
+)
+
+// statsdSample is a parsed StatsD line, e.g. "myapp.requests:1|c|@0.5|#endpoint:login".
+type statsdSample struct {
+	Name   string
+	Type   string //"c", "g", "s", or the timers "ms", "h" & "d".
+	Values []string
+	Rate   float64
+	Tags   map[string]string
+}
+
+// statsdSeries aggregates the samples of a series, the counters are cumulative while the timers & sets are reset on every flush.
+type statsdSeries struct {
+	Name    string
+	Labels  map[string]string
+	Type    string
+	Value   float64
+	Count   uint64
+	Sum     float64
+	Samples []float64
+	seen    int
+	Members map[string]bool
+}
+
+type statsdPodState struct {
+	Pod    *corev1.Pod
+	Series map[string]*statsdSeries
+	Types  map[string]string //type of every metric name, the samples with conflicting types are dropped.
+}
+
+// statsdReceiver receives the StatsD samples sent to the node, and attributes them to the PODs by their source IPs.
+type statsdReceiver struct {
+	mapping  *statsdMapping
+	interval time.Duration
+	lock     sync.Mutex
+	pods     map[types.UID]*statsdPodState
+}
+
+func initializeStatsDReceiver() {
+	if args.StatsDAddress == "" {
+		return
+	}
+	log.Infof("Initializing StatsD receiver on %s...", args.StatsDAddress)
+	prometheus.MustRegister(statsdSampleCounter)
+	mapping, err := loadStatsDMapping(args.StatsDMappingFile)
+	if err != nil {
+		log.Panicf("Failed to load StatsD mapping file: %s, error: %s", args.StatsDMappingFile, err.Error())
+	}
+	interval, err := parsePositiveDuration(args.StatsDFlushInterval)
+	if err != nil {
+		log.Panicf("Invalid StatsD flush interval \"%s\", error: %s", args.StatsDFlushInterval, err.Error())
+	}
+	r := &statsdReceiver{mapping: mapping, interval: interval, pods: map[types.UID]*statsdPodState{}}
+	udp, err := net.ListenPacket("udp", args.StatsDAddress)
+	if err != nil {
+		log.Panicf("Failed to listen StatsD UDP address: %s, error: %s", args.StatsDAddress, err.Error())
+	}
+	tcp, err := net.Listen("tcp", args.StatsDAddress)
+	if err != nil {
+		log.Panicf("Failed to listen StatsD TCP address: %s, error: %s", args.StatsDAddress, err.Error())
+	}
+	go r.serveUDP(udp)
+	go r.serveTCP(tcp)
+	go r.run()
+}
+
+func (r *statsdReceiver) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, statsdMaxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Errorf("Failed to read StatsD UDP packet, error: %s", err.Error())
+			continue
+		}
+		r.handle(addr.String(), strings.Split(string(buf[:n]), "\n"))
+	}
+}
+
+// serveTCP serves at most statsdMaxConnections connections, each of them is closed if no line is received within the idle timeout.
+func (r *statsdReceiver) serveTCP(listener net.Listener) {
+	tokens := make(chan struct{}, statsdMaxConnections)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Errorf("Failed to accept StatsD TCP connection, error: %s", err.Error())
+			continue
+		}
+		select {
+		case tokens <- struct{}{}:
+		default:
+			log.Debugf("Rejected StatsD TCP connection from %s since there are already %d connections.", conn.RemoteAddr().String(), statsdMaxConnections)
+			conn.Close()
+			continue
+		}
+		go func(conn net.Conn) {
+			defer func() { <-tokens }()
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			scanner.Buffer(make([]byte, 4096), statsdMaxPacketSize)
+			for {
+				conn.SetReadDeadline(time.Now().Add(statsdIdleTimeout))
+				if !scanner.Scan() {
+					return
+				}
+				r.handle(conn.RemoteAddr().String(), []string{scanner.Text()})
+			}
+		}(conn)
+	}
+}
+
+func (r *statsdReceiver) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.flush()
+	}
+}
+
+// handle aggregates the lines sent from the remote address into the state of the POD which owns the address.
+func (r *statsdReceiver) handle(remote string, lines []string) {
+	samples := []statsdSample{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		sample, err := parseStatsDLine(line)
+		if err != nil {
+			statsdSampleCounter.WithLabelValues("invalid").Inc()
+			log.Debugf("Dropped invalid StatsD line \"%s\" from %s, error: %s", line, remote, err.Error())
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	if len(samples) == 0 {
+		return
+	}
+	pod := podByIP(remote)
+	if pod == nil {
+		statsdSampleCounter.WithLabelValues("unattributed").Add(float64(len(samples)))
+		log.Debugf("Dropped %d StatsD samples from %s which is NOT owned by any POD on current node.", len(samples), remote)
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	state, ok := r.pods[pod.UID]
+	if !ok {
+		state = &statsdPodState{Series: map[string]*statsdSeries{}, Types: map[string]string{}}
+		r.pods[pod.UID] = state
+	}
+	state.Pod = pod
+	for _, sample := range samples {
+		if r.aggregate(state, sample) {
+			statsdSampleCounter.WithLabelValues("accepted").Inc()
+		} else {
+			statsdSampleCounter.WithLabelValues("dropped").Inc()
+		}
+	}
+}
+
+func (r *statsdReceiver) aggregate(state *statsdPodState, sample statsdSample) bool {
+	name, labels, ok := r.mapping.apply(sample.Name, sample.Tags)
+	if !ok {
+		return false
+	}
+	kind := sample.Type
+	if kind == "h" || kind == "d" {
+		kind = "ms"
+	}
+	if t, ok := state.Types[name]; ok && t != kind {
+		return false
+	}
+	if args.ScrapeLimits.LabelLimit > 0 && len(labels) > args.ScrapeLimits.LabelLimit {
+		return false
+	}
+	key := seriesSignature(name, labels)
+	series, ok := state.Series[key]
+	if !ok {
+		if args.ScrapeLimits.SampleLimit > 0 && len(state.Series) >= args.ScrapeLimits.SampleLimit {
+			return false
+		}
+		series = &statsdSeries{Name: name, Labels: labels, Type: kind}
+		state.Series[key] = series
+		state.Types[name] = kind
+	}
+	for _, value := range sample.Values {
+		series.add(sample, value)
+	}
+	return true
+}
+
+func (s *statsdSeries) add(sample statsdSample, value string) {
+	if s.Type == "s" {
+		if s.Members == nil {
+			s.Members = map[string]bool{}
+		}
+		s.Members[value] = true
+		return
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	switch s.Type {
+	case "c":
+		s.Value += f / sample.Rate
+	case "g":
+		//a signed value changes the gauge rather than sets it.
+		if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+			s.Value += f
+		} else {
+			s.Value = f
+		}
+	case "ms":
+		//the timers are in milliseconds while the histograms & distributions are not.
+		if sample.Type == "ms" {
+			f /= 1000
+		}
+		count := 1.0 / sample.Rate
+		s.Count += uint64(count + 0.5)
+		s.Sum += f * count
+		//keeps a uniform subset of the samples for the quantiles.
+		s.seen++
+		if len(s.Samples) < statsdMaxTimerSamples {
+			s.Samples = append(s.Samples, f)
+		} else if i := rand.Intn(s.seen); i < statsdMaxTimerSamples {
+			s.Samples[i] = f
+		}
+	}
+}
+
+// flush pushes the aggregated metrics of every POD, and forgets the PODs which have been deleted.
+func (r *statsdReceiver) flush() {
+	pushes := map[*corev1.Pod][]*dto.MetricFamily{}
+	gone := []*corev1.Pod{}
+	r.lock.Lock()
+	for uid, state := range r.pods {
+		if !podExists(state.Pod) {
+			gone = append(gone, state.Pod)
+			delete(r.pods, uid)
+			continue
+		}
+		pushes[state.Pod] = state.build()
+	}
+	r.lock.Unlock()
+	for _, pod := range gone {
+		log.Debugf("Removing StatsD metrics of deleted POD: %s", pod.Name)
+		deleteReceivedMetrics(pod, statsdSource)
+	}
+	for pod, families := range pushes {
+		if err := pushReceivedMetrics(pod, statsdSource, families); err != nil {
+			log.Errorf("Failed to encode StatsD metrics, POD: %s, error: %s", pod.Name, err.Error())
+		}
+	}
+}
+
+// build converts the series into metric families, and resets the samples of current interval.
+func (state *statsdPodState) build() []*dto.MetricFamily {
+	keys := make([]string, 0, len(state.Series))
+	for key := range state.Series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	byName := map[string]*dto.MetricFamily{}
+	families := []*dto.MetricFamily{}
+	for _, key := range keys {
+		s := state.Series[key]
+		family, ok := byName[s.Name]
+		if !ok {
+			name := s.Name
+			family = &dto.MetricFamily{Name: &name, Type: s.metricType().Enum()}
+			byName[name] = family
+			families = append(families, family)
+		}
+		family.Metric = append(family.Metric, s.build())
+		s.Samples, s.seen, s.Members = nil, 0, nil
+	}
+	sortMetricFamilies(families)
+	return families
+}
+
+func (s *statsdSeries) metricType() dto.MetricType {
+	switch s.Type {
+	case "c":
+		return dto.MetricType_COUNTER
+	case "ms":
+		return dto.MetricType_SUMMARY
+	}
+	return dto.MetricType_GAUGE
+}
+
+func (s *statsdSeries) build() *dto.Metric {
+	metric := &dto.Metric{}
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		name, value := name, s.Labels[name]
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	switch s.Type {
+	case "c":
+		value := s.Value
+		metric.Counter = &dto.Counter{Value: &value}
+	case "s":
+		value := float64(len(s.Members))
+		metric.Gauge = &dto.Gauge{Value: &value}
+	case "ms":
+		count, sum := s.Count, s.Sum
+		metric.Summary = &dto.Summary{SampleCount: &count, SampleSum: &sum}
+		if len(s.Samples) > 0 {
+			samples := append([]float64{}, s.Samples...)
+			sort.Float64s(samples)
+			for _, q := range statsdQuantiles {
+				q, value := q, samples[int(q*float64(len(samples)-1))]
+				metric.Summary.Quantile = append(metric.Summary.Quantile, &dto.Quantile{Quantile: &q, Value: &value})
+			}
+		}
+	default:
+		value := s.Value
+		metric.Gauge = &dto.Gauge{Value: &value}
+	}
+	return metric
+}
+
+// parseStatsDLine parses a line formatted as "name:value[:value...]|type[|@rate][|#tag:value,...]" (DogStatsD tags included).
+func parseStatsDLine(line string) (statsdSample, error) {
+	sample := statsdSample{Rate: 1}
+	sections := strings.Split(line, "|")
+	if len(sections) < 2 {
+		return sample, fmt.Errorf("missing type")
+	}
+	idx := strings.Index(sections[0], ":")
+	if idx <= 0 || idx == len(sections[0])-1 {
+		return sample, fmt.Errorf("missing name or value")
+	}
+	sample.Name, sample.Values = sections[0][:idx], strings.Split(sections[0][idx+1:], ":")
+	sample.Type = sections[1]
+	switch sample.Type {
+	case "c", "g", "s", "ms", "h", "d":
+	default:
+		return sample, fmt.Errorf("unsupported type \"%s\"", sample.Type)
+	}
+	for _, section := range sections[2:] {
+		switch {
+		case strings.HasPrefix(section, "@"):
+			rate, err := strconv.ParseFloat(section[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return sample, fmt.Errorf("invalid sample rate \"%s\"", section[1:])
+			}
+			sample.Rate = rate
+		case strings.HasPrefix(section, "#"):
+			sample.Tags = parseStatsDTags(section[1:])
+		}
+		//the other DogStatsD extensions (e.g. timestamps & container IDs) are ignored.
+	}
+	return sample, nil
+}
+
+// parseStatsDTags parses the DogStatsD tags formatted as "key:value,key:value", the tags without values are ignored.
+func parseStatsDTags(tags string) map[string]string {
+	labels := map[string]string{}
+	for _, tag := range strings.Split(tags, ",") {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		name := sanitizeLabelName(kv[0])
+		if isGroupingLabel(name) {
+			continue
+		}
+		labels[name] = kv[1]
+	}
+	return labels
+}
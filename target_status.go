@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	targetStatuses         = make(map[types.UID]*TargetStatus)
+	targetStatusLock       = &sync.RWMutex{}
+	targetConfigErrorGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "crystal_bridge_target_config_error", Help: "Whether the annotations of an annotated POD are invalid (1) or not (0)."}, []string{"namespace", "pod"})
+)
+
+// TargetStatus is the structured status of an annotated POD, exposed through the admin API.
+type TargetStatus struct {
+	Namespace       string    `json:"namespace"`
+	Pod             string    `json:"pod"`
+	UID             types.UID `json:"uid"`
+	MetricType      string    `json:"type"`
+	Endpoints       string    `json:"endpoints"`
+	Targets         []string  `json:"targets"`
+	HostNetwork     bool      `json:"hostNetwork,omitempty"`
+	Valid           bool      `json:"valid"`
+	Errors          []string  `json:"errors"`
+	LastScrape      time.Time `json:"lastScrape,omitempty"`
+	LastScrapeError string    `json:"lastScrapeError,omitempty"`
+}
+
+func recordTargetStatus(e *PODEvent) {
+	targetStatusLock.Lock()
+	defer targetStatusLock.Unlock()
+	status, ok := targetStatuses[e.Pod.UID]
+	if !ok {
+		status = &TargetStatus{Namespace: e.Pod.Namespace, Pod: e.Pod.Name, UID: e.Pod.UID}
+		targetStatuses[e.Pod.UID] = status
+	}
+	status.MetricType = e.MetricType
+	status.Endpoints = e.Endpoints
+	status.HostNetwork = e.Pod.Spec.HostNetwork
+	status.Targets = make([]string, 0, len(e.Targets))
+	for _, t := range e.Targets {
+		status.Targets = append(status.Targets, t.String())
+	}
+	status.Valid = len(e.Errors) == 0
+	status.Errors = append([]string{}, e.Errors...)
+	if status.Valid {
+		targetConfigErrorGauge.WithLabelValues(e.Pod.Namespace, e.Pod.Name).Set(0)
+	} else {
+		targetConfigErrorGauge.WithLabelValues(e.Pod.Namespace, e.Pod.Name).Set(1)
+	}
+}
+
+func forgetTargetStatus(pod *corev1.Pod) {
+	targetStatusLock.Lock()
+	defer targetStatusLock.Unlock()
+	if _, ok := targetStatuses[pod.UID]; ok {
+		delete(targetStatuses, pod.UID)
+		targetConfigErrorGauge.DeleteLabelValues(pod.Namespace, pod.Name)
+	}
+}
+
+func reportScrapeResult(uid types.UID, err error) {
+	targetStatusLock.Lock()
+	defer targetStatusLock.Unlock()
+	status, ok := targetStatuses[uid]
+	if !ok {
+		return
+	}
+	status.LastScrape = time.Now()
+	status.LastScrapeError = ""
+	if err != nil {
+		status.LastScrapeError = err.Error()
+	}
+}
+
+// listTargetStatuses returns copies of all statuses sorted by namespace and POD name.
+func listTargetStatuses() []TargetStatus {
+	targetStatusLock.RLock()
+	defer targetStatusLock.RUnlock()
+	statuses := make([]TargetStatus, 0, len(targetStatuses))
+	for _, v := range targetStatuses {
+		statuses = append(statuses, *v)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Namespace != statuses[j].Namespace {
+			return statuses[i].Namespace < statuses[j].Namespace
+		}
+		return statuses[i].Pod < statuses[j].Pod
+	})
+	return statuses
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDestinationName     = "default"
+	unknownTenantPolicyDrop    = "drop"
+	unknownTenantPolicyDefault = "default"
+	//maximum count of the remembered warnings of the tenant annotations.
+	tenantWarningCacheSize = 1024
+)
+
+var (
+	router             *pushRouter
+	pushDroppedCounter = prometheus.NewCounter(prometheus.CounterOpts{Name: "push_prometheus_metrics_dropped_count_total", Help: "Total count of dropped metric data which could not be routed to any push gateway."})
+)
+
+// routingConfig is the content of the file given by the "-routes" argument.
+type routingConfig struct {
+	Destinations        []*pushDestinationConfig `yaml:"destinations"`
+	Rules               []*routingRule           `yaml:"rules"`
+	Default             string                   `yaml:"default"`
+	UnknownTenantPolicy string                   `yaml:"unknownTenantPolicy"`
+}
+
+// routingRule matches PODs by namespaces AND labels, an empty condition matches everything.
+type routingRule struct {
+	Namespaces  []string          `yaml:"namespaces"`
+	Labels      map[string]string `yaml:"labels"`
+	Destination string            `yaml:"destination"`
+}
+
+func (r *routingRule) match(data *PrometheusData) bool {
+	if len(r.Namespaces) > 0 {
+		matched := false
+		for _, ns := range r.Namespaces {
+			if ns == data.Namespace {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for k, v := range r.Labels {
+		if data.PodLabels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// pushRouter picks the push gateway for every metric data by its tenant annotation or by the routing rules.
+type pushRouter struct {
+	destinations       map[string]*pushDestination
+	rules              []*routingRule
+	defaultDestination *pushDestination
+	dropUnknown        bool
+	warningLock        sync.Mutex
+	warned             map[string]bool //PODs & tenants which have been warned about.
+}
+
+func newPushRouter(defaultDest *pushDestination, file string, timeout time.Duration) (*pushRouter, error) {
+	r := &pushRouter{destinations: map[string]*pushDestination{}, defaultDestination: defaultDest, warned: map[string]bool{}}
+	if defaultDest != nil {
+		r.destinations[defaultDest.Name] = defaultDest
+	}
+	if file == "" {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := routingConfig{}
+	if err = yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("Failed to parse routing file: %s, error: %s", file, err.Error())
+	}
+	for _, dc := range cfg.Destinations {
+		if dc.Name == "" {
+			return nil, fmt.Errorf("name of destination (%s) CANNOT be empty", dc.Address)
+		}
+		dc.Timeout = timeout
+		dest, err := newPushDestination(dc)
+		if err != nil {
+			return nil, err
+		}
+		r.destinations[dc.Name] = dest
+	}
+	for _, rule := range cfg.Rules {
+		if _, ok := r.destinations[rule.Destination]; !ok {
+			return nil, fmt.Errorf("routing rule refers to an unknown destination: \"%s\"", rule.Destination)
+		}
+	}
+	r.rules = cfg.Rules
+	if cfg.Default != "" {
+		dest, ok := r.destinations[cfg.Default]
+		if !ok {
+			return nil, fmt.Errorf("default route refers to an unknown destination: \"%s\"", cfg.Default)
+		}
+		r.defaultDestination = dest
+	}
+	switch cfg.UnknownTenantPolicy {
+	case "", unknownTenantPolicyDefault:
+	case unknownTenantPolicyDrop:
+		r.dropUnknown = true
+	default:
+		return nil, fmt.Errorf("unsupported unknown tenant policy: \"%s\"", cfg.UnknownTenantPolicy)
+	}
+	return r, nil
+}
+
+// route returns nil if the data should be dropped. The tenant annotation is honored ONLY if the destination allows
+// the POD's namespace, otherwise the POD could push its metrics with the credentials of other tenants.
+func (r *pushRouter) route(data *PrometheusData) *pushDestination {
+	if data.Tenant != "" {
+		dest, ok := r.destinations[data.Tenant]
+		if !ok {
+			r.warnOnce(data, "POD: %s/%s has been annotated with an unknown tenant: %s", data.Namespace, data.PodName, data.Tenant)
+			return r.fallback()
+		}
+		if dest.annotationNamespaces[data.Namespace] || dest.annotationNamespaces["*"] {
+			return dest
+		}
+		r.warnOnce(data, "POD: %s/%s has been annotated with tenant: %s which does NOT allow its namespace, routed by the rules instead.", data.Namespace, data.PodName, data.Tenant)
+	}
+	for _, rule := range r.rules {
+		if rule.match(data) {
+			return r.destinations[rule.Destination]
+		}
+	}
+	return r.fallback()
+}
+
+// warnOnce logs the warning of the tenant annotation ONLY once for every POD & tenant, rather than on every push.
+func (r *pushRouter) warnOnce(data *PrometheusData, format string, values ...interface{}) {
+	key := string(data.PodUID) + "/" + data.Tenant
+	r.warningLock.Lock()
+	defer r.warningLock.Unlock()
+	if r.warned[key] {
+		return
+	}
+	if len(r.warned) >= tenantWarningCacheSize {
+		r.warned = map[string]bool{}
+	}
+	r.warned[key] = true
+	log.Warnf(format, values...)
+}
+
+func (r *pushRouter) fallback() *pushDestination {
+	if r.dropUnknown {
+		return nil
+	}
+	return r.defaultDestination
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"time"
+)
+
+const (
+	//key of the ConfigMap referenced by the "/config-map" annotation if no key is given.
+	defaultConfigMapKey = "config.yaml"
+	//the changes of the ConfigMaps are applied after the TTL at most.
+	configMapCacheTTL = time.Minute
+)
+
+var (
+	configMapCache = newLookupCache(configMapCacheTTL)
+)
+
+// parseTypeConfig reads the type specific configuration, either inline ("/config") or referenced ("/config-map", formatted as "name" or "name/key").
+func (e *PODEvent) parseTypeConfig() {
+	e.Config, _ = e.annotation("config")
+	e.ConfigMap, _ = e.annotation("config-map")
+	collector, ok := metricsCollectors[e.MetricType]
+	if !ok {
+		return
+	}
+	if e.Config != "" && e.ConfigMap != "" {
+		e.addError("annotations \"%s/config\" and \"%s/config-map\" CANNOT be used together", args.AnnotationPrefixTag, args.AnnotationPrefixTag)
+		return
+	}
+	if collector.RequiresConfig && e.Config == "" && e.ConfigMap == "" {
+		e.addError("annotation \"%s/config\" or \"%s/config-map\" is required by type \"%s\"", args.AnnotationPrefixTag, args.AnnotationPrefixTag, e.MetricType)
+		return
+	}
+	if e.ConfigMap != "" {
+		if name, _ := splitConfigMapReference(e.ConfigMap); name == "" {
+			e.addError("invalid config-map \"%s\", it must be formatted as \"name\" or \"name/key\"", e.ConfigMap)
+		}
+	}
+	//the referenced ConfigMap will be validated on fetching since it can be changed at any time.
+	if e.Config != "" && collector.ValidateConfig != nil {
+		if err := collector.ValidateConfig([]byte(e.Config)); err != nil {
+			e.addError("invalid config: %s", err.Error())
+		}
+	}
+}
+
+func splitConfigMapReference(reference string) (string, string) {
+	parts := strings.SplitN(reference, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], defaultConfigMapKey
+	}
+	return parts[0], parts[1]
+}
+
+// loadTypeConfig returns the type specific configuration of the POD, nil if nothing is configured.
+func loadTypeConfig(e *PODEvent) ([]byte, error) {
+	if e.Config != "" {
+		return []byte(e.Config), nil
+	}
+	if e.ConfigMap == "" {
+		return nil, nil
+	}
+	name, key := splitConfigMapReference(e.ConfigMap)
+	obj, err := configMapCache.get(e.Pod.Namespace+"/"+name, func() (interface{}, error) {
+		return k8sClient.CoreV1().ConfigMaps(e.Pod.Namespace).Get(name, metav1.GetOptions{})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load ConfigMap %s/%s, error: %s", e.Pod.Namespace, name, err.Error())
+	}
+	cm := obj.(*corev1.ConfigMap)
+	data, ok := cm.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("ConfigMap %s/%s has no key \"%s\"", e.Pod.Namespace, name, key)
+	}
+	return []byte(data), nil
+}